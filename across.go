@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// ReadAcross reads every record of the given collections, in order, into one
+// slice of T, for records sharing a schema but living apart, such as
+// users_active and users_archived. A missing collection is an error; see
+// ReadAcrossExisting.
+func ReadAcross[T any](d *Driver, collections ...string) ([]T, error) {
+	return readAcross[T](d, false, collections)
+}
+
+// ReadAcrossExisting is ReadAcross skipping collections that don't exist.
+func ReadAcrossExisting[T any](d *Driver, collections ...string) ([]T, error) {
+	return readAcross[T](d, true, collections)
+}
+
+func readAcross[T any](d *Driver, skipMissing bool, collections []string) ([]T, error) {
+	var records []T
+
+	for _, collection := range collections {
+		raws, err := d.ReadAllRaw(collection)
+		if skipMissing && os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, raw := range raws {
+			var v T
+			if err := d.unmarshal(raw, &v); err != nil {
+				return nil, fmt.Errorf("Unable to decode a record of '%s': %w", collection, err)
+			}
+			records = append(records, v)
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestReadAcross(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users_active", "a", User{Name: "A"})
+	d.Write("users_active", "b", User{Name: "B"})
+	d.Write("users_archived", "c", User{Name: "C"})
+	us, err := ReadAcross[User](d, "users_active", "users_archived")
+	if err != nil || len(us) != 3 || us[2].Name != "C" {
+		t.Fatal(us, err)
+	}
+	if _, err := ReadAcross[User](d, "users_active", "nope"); err == nil {
+		t.Fatal("expected an error for a missing collection")
+	}
+	if us, err = ReadAcrossExisting[User](d, "nope", "users_archived"); err != nil || len(us) != 1 {
+		t.Fatal(us, err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BatchError lists the records of a batch write that failed.
+type BatchError struct {
+	Failures []BatchFailure
+}
+
+// BatchFailure is one record of a batch write that failed, and why.
+type BatchFailure struct {
+	Resource string
+	Err      error
+}
+
+func (e *BatchError) Error() string {
+	msgs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		msgs[i] = fmt.Sprintf("'%s': %v", f.Resource, f.Err)
+	}
+	return fmt.Sprintf("Batch write failed for %d record(s) - %s", len(e.Failures), strings.Join(msgs, "; "))
+}
+
+// Unwrap lets errors.Is and errors.As look through to the individual
+// failures.
+func (e *BatchError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, f := range e.Failures {
+		errs[i] = f.Err
+	}
+	return errs
+}
+
+// WriteBatch writes every record of records, keyed by resource, under a
+// single collection lock. A failing record doesn't stop the others: WriteBatch
+// returns the resources that were written and, if any failed, a *BatchError
+// listing the rest.
+func (d *Driver) WriteBatch(collection string, records map[string]interface{}) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - no place to save the records!")
+	}
+
+	resources, encoded, failed := d.prepareBatch(collection, records)
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var written []string
+	for _, resource := range resources {
+		if b, ok := encoded[resource]; ok {
+			if err := d.writeFile(collection, resource, b); err != nil {
+				failed = append(failed, BatchFailure{resource, err})
+				continue
+			}
+			written = append(written, resource)
+		}
+	}
+
+	if len(failed) > 0 {
+		sortFailures(failed)
+		return written, &BatchError{Failures: failed}
+	}
+
+	return written, nil
+}
+
+// WriteAll is WriteBatch reporting per record: the returned map holds an
+// entry for every resource of records, nil where the write succeeded. The
+// error is only set when nothing could be attempted, e.g. on a lock timeout.
+func (d *Driver) WriteAll(collection string, records map[string]interface{}) (map[string]error, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - no place to save the records!")
+	}
+
+	resources, encoded, failed := d.prepareBatch(collection, records)
+
+	results := make(map[string]error, len(resources))
+	for _, f := range failed {
+		results[f.Resource] = f.Err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	for _, resource := range resources {
+		if b, ok := encoded[resource]; ok {
+			results[resource] = d.writeFile(collection, resource, b)
+		}
+	}
+
+	return results, nil
+}
+
+// Seed creates each record of records, keyed by resource, that doesn't exist
+// yet, leaving existing ones untouched, and returns how many it created. Run
+// at startup it bootstraps default data idempotently. It stops at the first
+// record that fails for any other reason.
+func (d *Driver) Seed(collection string, records map[string]interface{}) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - no place to save the records!")
+	}
+
+	resources, encoded, failed := d.prepareBatch(collection, records)
+	if len(failed) > 0 {
+		return 0, &BatchError{Failures: failed}
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	created := 0
+	for _, resource := range resources {
+		err := d.createFile(collection, resource, encoded[resource])
+		switch {
+		case errors.Is(err, ErrAlreadyExists):
+			continue
+		case err != nil:
+			return created, err
+		}
+		created++
+	}
+
+	return created, nil
+}
+
+// WriteBatchAtomic is the all-or-nothing WriteBatch: every record is staged in
+// a temp file first, and only once all of them staged are they renamed into
+// place. If any record fails, the staged files are removed, nothing is
+// written or evicted, and the *BatchError lists every failure. The renames
+// themselves are not atomic as a group: should one fail, say on a full disk,
+// the records renamed before it stay written and the rest are discarded.
+func (d *Driver) WriteBatchAtomic(collection string, records map[string]interface{}) error {
+	if collection == "" {
+		return errors.New("Missing Collection - no place to save the records!")
+	}
+
+	resources, encoded, failed := d.prepareBatch(collection, records)
+	if len(failed) > 0 {
+		return &BatchError{Failures: failed}
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	staged := make([]stagedFile, 0, len(resources))
+	evict := 0
+
+	discard := func(staged []stagedFile) {
+		for _, s := range staged {
+			os.Remove(s.tmpPath)
+			if s.added {
+				d.adjustCount(collection, -1)
+			}
+		}
+	}
+
+	for _, resource := range resources {
+		fnlPath := d.recordPath(collection, resource) + ".json"
+
+		s, err := d.stageFile(collection, resource, fnlPath, encoded[resource])
+		if err != nil {
+			failed = append(failed, BatchFailure{resource, err})
+			continue
+		}
+
+		// Count the insert and the evictions it needs right away so the
+		// collection cap sees the whole batch, not just the records already
+		// on disk.
+		if s.added {
+			d.adjustCount(collection, 1)
+		}
+		d.adjustCount(collection, -s.evict)
+		evict += s.evict
+		staged = append(staged, s)
+	}
+
+	if len(failed) > 0 {
+		discard(staged)
+		d.adjustCount(collection, evict)
+		return &BatchError{Failures: failed}
+	}
+
+	// Room is made only now, so a batch that fails to stage evicts nothing.
+	if evict > 0 {
+		keep := make(map[string]bool, len(staged))
+		for _, s := range staged {
+			keep[trimRecordExt(s.fnlPath)] = true
+		}
+
+		removed, err := d.evictOldest(collection, evict, keep)
+		d.adjustCount(collection, evict-removed)
+		if err != nil {
+			discard(staged)
+			return err
+		}
+	}
+
+	for i, s := range staged {
+		if err := d.commitFile(s.tmpPath, s.fnlPath); err != nil {
+			discard(staged[i:])
+			return err
+		}
+
+		err := removeVariants(s.fnlPath)
+		if err == nil {
+			err = d.clearTombstone(s.fnlPath)
+		}
+		if err != nil {
+			discard(staged[i+1:])
+			return err
+		}
+	}
+
+	return nil
+}
+
+// prepareBatch validates and marshals the records of a batch, outside the
+// collection lock. It returns the resources in order, the encoded records and
+// the failures.
+func (d *Driver) prepareBatch(collection string, records map[string]interface{}) ([]string, map[string][]byte, []BatchFailure) {
+	resources := make([]string, 0, len(records))
+	for resource := range records {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	encoded := make(map[string][]byte, len(records))
+	var failed []BatchFailure
+
+	for _, resource := range resources {
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			failed = append(failed, BatchFailure{resource, err})
+			continue
+		}
+
+		b, err := d.marshal(records[resource])
+		if err != nil {
+			failed = append(failed, BatchFailure{resource, err})
+			continue
+		}
+
+		encoded[resource] = b
+	}
+
+	return resources, encoded, failed
+}
+
+// stagedFile is a record staged by stageFile, waiting to be committed.
+type stagedFile struct {
+	tmpPath string
+	fnlPath string
+	added   bool
+	// evict is the number of records to evict to make room for it.
+	evict int
+}
+
+// stageFile is writeFile up to, but not including, the final rename: b is
+// checked against the collection's rules and left in a temp file beside the
+// variant of fnlPath it will be stored as. Unlike writeFile it evicts
+// nothing, leaving that to the caller.
+func (d *Driver) stageFile(collection string, resource string, fnlPath string, b []byte) (stagedFile, error) {
+	if err := d.checkFieldTypes(collection, resource, bytes.NewReader(b)); err != nil {
+		return stagedFile{}, err
+	}
+
+	added, evict, err := d.admission(collection, resource)
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	if err := d.prepareCollection(collection, resource); err != nil {
+		return stagedFile{}, err
+	}
+
+	if fnlPath, b, err = d.storedRecord(collection, fnlPath, b); err != nil {
+		return stagedFile{}, err
+	}
+
+	tmpPath, err := d.writeTemp(fnlPath, b)
+	if err != nil {
+		return stagedFile{}, err
+	}
+
+	return stagedFile{tmpPath, fnlPath, added, evict}, nil
+}
+
+func sortFailures(failed []BatchFailure) {
+	sort.Slice(failed, func(i, j int) bool {
+		return failed[i].Resource < failed[j].Resource
+	})
+}
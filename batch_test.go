@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteBatch(t *testing.T) {
+	d := newDB(t, nil)
+	d.SetFieldTypes("c", map[string]string{"n": "number"})
+	recs := map[string]interface{}{"a": map[string]int{"n": 1}, "b": map[string]string{"n": "x"}, "c": map[string]int{"n": 3}}
+	if err := d.WriteBatchAtomic("c", recs); err == nil {
+		t.Fatal("no error")
+	} else {
+		var be *BatchError
+		if !errors.As(err, &be) || len(be.Failures) != 1 || be.Failures[0].Resource != "b" || !errors.Is(err, ErrFieldType) {
+			t.Fatal(err)
+		}
+	}
+	if n, _ := d.Count("c"); n != 0 {
+		t.Fatal(n)
+	}
+	entries, _ := os.ReadDir(filepath.Join(d.dir, "c"))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Fatal(e.Name())
+		}
+	}
+	written, err := d.WriteBatch("c", recs)
+	if len(written) != 2 || err == nil {
+		t.Fatal(written, err)
+	}
+	recs["b"] = map[string]int{"n": 2}
+	if err := d.WriteBatchAtomic("c", recs); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := d.Count("c"); n != 3 {
+		t.Fatal(n)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("cfg", "a", "mine")
+	recs := map[string]interface{}{"a": "default", "b": 1, "c": 2}
+	if n, err := d.Seed("cfg", recs); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, err := d.Seed("cfg", recs); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	var s string
+	if d.Read("cfg", "a", &s); s != "mine" {
+		t.Fatal(s)
+	}
+}
+
+func TestWriteAll(t *testing.T) {
+	d := newDB(t, nil)
+	res, err := d.WriteAll("c", map[string]interface{}{"a": 1, "bad": make(chan int), "x/y": 2})
+	if err != nil || len(res) != 3 || res["a"] != nil || res["bad"] == nil || res["x/y"] == nil {
+		t.Fatal(res, err)
+	}
+	if n, _ := d.Count("c"); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestWriteBatchAtomicPolicies(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.SetCollectionPolicy("c", Policy{MaxRecords: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetFieldTypes("c", map[string]string{"v": "number"}); err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range []string{"a", "b", "c"} {
+		if err := d.Write("c", r, map[string]int{"v": i}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	err := d.WriteBatchAtomic("c", map[string]interface{}{"d": map[string]int{"v": 1}, "e": map[string]string{"v": "x"}})
+	if err == nil {
+		t.Fatal("expected failure")
+	}
+	if list, _ := d.List("c"); len(list) != 3 {
+		t.Fatalf("after failed batch: %v", list)
+	}
+	if n, _ := d.Count("c"); n != 3 {
+		t.Fatalf("count %d", n)
+	}
+	if err := d.WriteBatchAtomic("c", map[string]interface{}{"d": map[string]int{"v": 1}, "e": map[string]int{"v": 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := d.List("c"); !reflect.DeepEqual(list, []string{"c", "d", "e"}) {
+		t.Fatalf("after batch: %v", list)
+	}
+	if n, _ := d.Count("c"); n != 3 {
+		t.Fatalf("count %d", n)
+	}
+
+	// A failing rename leaves no temp files behind.
+	d2 := newDB(t, nil)
+	os.MkdirAll(filepath.Join(d2.dir, "x", "b.json", "y"), 0755)
+	err = d2.WriteBatchAtomic("x", map[string]interface{}{"a": 1, "b": 2, "c": 3})
+	if err == nil {
+		t.Fatal("expected rename failure")
+	}
+	entries, _ := os.ReadDir(filepath.Join(d2.dir, "x"))
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Fatalf("leaked %s", e.Name())
+		}
+	}
+}
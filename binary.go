@@ -0,0 +1,88 @@
+package main
+
+import "os"
+
+// binExt is the extension of the raw byte files WriteBlob stores next to
+// records. They are not records and never show up in listings.
+const binExt = ".bin"
+
+// WriteBlob stores data, a binary attachment such as an image or a PDF, as
+// resource's .bin file next to its JSON record, atomically and under the
+// collection lock. The record itself need not exist; Delete removes both.
+func (d *Driver) WriteBlob(collection string, resource string, data []byte) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.prepareCollection(collection, resource); err != nil {
+		return err
+	}
+
+	binPath := d.recordPath(collection, resource) + binExt
+
+	tmpPath, err := d.writeTemp(binPath, data)
+	if err != nil {
+		return err
+	}
+
+	if err := d.commitFile(tmpPath, binPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return nil
+}
+
+// ReadBlob returns the binary attachment WriteBlob stored for resource,
+// failing with ErrRecordNotFound if there is none.
+func (d *Driver) ReadBlob(collection string, resource string) ([]byte, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	b, err := os.ReadFile(d.recordPath(collection, resource) + binExt)
+	if err != nil {
+		return nil, recordError(collection, resource, err)
+	}
+
+	return b, nil
+}
+
+// removeBinary removes the attachment of the record at path, which may name
+// any variant of it, and reports whether there was one.
+func removeBinary(path string) (bool, error) {
+	err := os.Remove(trimRecordExt(path) + binExt)
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return true, nil
+}
+
+// moveBinary moves the attachment of the record at from, if it has one, along
+// with the record to to.
+func moveBinary(from string, to string) error {
+	err := os.Rename(trimRecordExt(from)+binExt, trimRecordExt(to)+binExt)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestBlobs(t *testing.T) {
+	d := newDB(t, nil)
+	data := []byte{0, 1, 2, 0xff, '{', '\n'}
+	d.Write("docs", "a", map[string]string{"title": "x"})
+	if err := d.WriteBlob("docs", "a", data); err != nil {
+		t.Fatal(err)
+	}
+	got, err := d.ReadBlob("docs", "a")
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatal(got, err)
+	}
+	if l, _ := d.List("docs"); len(l) != 1 {
+		t.Fatal(l)
+	}
+	if err := d.Delete("docs", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadBlob("docs", "a"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	d.WriteBlob("docs", "lone", data)
+	if err := d.Delete("docs", "lone"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("docs", "lone"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	d.Write("docs", "s", 1)
+	d.WriteBlob("docs", "s", data)
+	d.opts.Sharding = 1
+	if _, err := d.Reshard("docs"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := d.ReadBlob("docs", "s"); err != nil || !bytes.Equal(got, data) {
+		t.Fatal(err)
+	}
+}
+
+func TestBlobsRemovedWithRecords(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	d.WriteBlob("c", "a", []byte("x"))
+	if _, err := d.DeleteWhere("c", func(json.RawMessage) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ReadBlob("c", "a"); err == nil {
+		t.Fatal("attachment survived DeleteWhere")
+	}
+	d.SetCollectionPolicy("c", Policy{MaxRecords: 1})
+	d.Write("c", "a", 1)
+	d.WriteBlob("c", "a", []byte("x"))
+	d.Write("c", "b", 1)
+	if _, err := d.ReadBlob("c", "a"); err == nil {
+		t.Fatal("attachment survived eviction")
+	}
+}
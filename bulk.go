@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// UpdateMany shallow-merges patch into every record in collection for which
+// match returns true and reports how many records were updated. Each record is
+// rewritten atomically under the collection lock. Under Options.DryRun the
+// matches are counted but not written.
+func (d *Driver) UpdateMany(collection string, match func(json.RawMessage) bool, patch map[string]interface{}) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to update!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	if _, err := stat(dir); err != nil {
+		return 0, err
+	}
+
+	resources, err := d.listResources(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var updated []string
+
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if err != nil {
+			return len(updated), err
+		}
+
+		if !match(b) {
+			continue
+		}
+
+		if !d.opts.DryRun {
+			b, err = d.patchRecord(b, patch)
+			if err != nil {
+				return len(updated), fmt.Errorf("Update failed for '%s': %w", resource, err)
+			}
+
+			if err := d.writeFile(collection, resource, b); err != nil {
+				return len(updated), err
+			}
+		}
+		updated = append(updated, resource)
+	}
+
+	if d.opts.DryRun {
+		d.logDryRun("update", collection, updated)
+	}
+
+	return len(updated), nil
+}
+
+// DeleteWhere removes every record in collection for which match returns true
+// and reports how many were removed. Matches are collected before anything is
+// deleted. Under Options.DryRun the matches are counted but left in place.
+func (d *Driver) DeleteWhere(collection string, match func(json.RawMessage) bool) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to delete!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	if _, err := stat(dir); err != nil {
+		return 0, err
+	}
+
+	resources, err := d.listResources(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.checkRemove(collection); err != nil {
+		return 0, err
+	}
+
+	var targets []string
+
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if err != nil {
+			return 0, err
+		}
+
+		if match(b) {
+			targets = append(targets, resource)
+		}
+	}
+
+	if d.opts.DryRun {
+		d.logDryRun("delete from", collection, targets)
+		return len(targets), nil
+	}
+
+	for i, resource := range targets {
+		path, _, _ := resolveRecord(d.recordPath(collection, resource) + ".json")
+		if err := os.Remove(path); err != nil {
+			return i, err
+		}
+		d.adjustCount(collection, -1)
+
+		if _, err := removeBinary(path); err != nil {
+			return i + 1, err
+		}
+
+		if err := d.tombstone(path); err != nil {
+			return i + 1, err
+		}
+	}
+
+	return len(targets), nil
+}
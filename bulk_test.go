@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestUpdateMany(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("user", "a", User{Name: "a", Address: Address{City: "Ikeja"}})
+	d.Write("user", "b", User{Name: "b", Address: Address{City: "Ikeja"}})
+	d.Write("user", "c", User{Name: "c", Address: Address{City: "Egbeda"}})
+	n, err := d.UpdateMany("user", func(raw json.RawMessage) bool {
+		var u User
+		json.Unmarshal(raw, &u)
+		return u.Address.City == "Ikeja"
+	}, map[string]interface{}{"Company": "X"})
+	if n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	var u User
+	d.Read("user", "c", &u)
+	if u.Company != "" {
+		t.Fatalf("unmatched record changed: %+v", u)
+	}
+	d.Read("user", "a", &u)
+	if u.Company != "X" || u.Address.City != "Ikeja" {
+		t.Fatal(u)
+	}
+}
+
+func TestDeleteWhere(t *testing.T) {
+	for _, dry := range []bool{false, true} {
+		d := newDB(t, &Options{DryRun: dry})
+		d.Write("s", "a", map[string]bool{"expired": true})
+		d.Write("s", "b", map[string]bool{"expired": false})
+		d.Write("s", "c", map[string]bool{"expired": true})
+		n, err := d.DeleteWhere("s", func(raw json.RawMessage) bool { return strings.Contains(string(raw), "true") })
+		if n != 2 || err != nil {
+			t.Fatal(n, err)
+		}
+		left, _ := d.Count("s")
+		if (dry && left != 3) || (!dry && left != 1) {
+			t.Fatal(dry, left)
+		}
+	}
+}
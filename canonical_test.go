@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCanonicalETag(t *testing.T) {
+	a, err := Canonicalize(json.RawMessage(`{"b": 1, "a": {"y": [1, 2.50, 1e21], "x": "< >"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Canonicalize(map[string]interface{}{"a": map[string]interface{}{"x": "< >", "y": []float64{1, 2.5, 1e21}}, "b": 1})
+	if string(a) != string(b) || string(a) != "{\"a\":{\"x\":\"< >\",\"y\":[1,2.5,1e+21]},\"b\":1}" {
+		t.Fatalf("%s\n%s", a, b)
+	}
+	for f, want := range map[float64]string{0.000001: "0.000001", 1e-7: "1e-7", -123.456: "-123.456", 1e20: "100000000000000000000", 4.5e300: "4.5e+300"} {
+		if got := canonicalNumber(f); got != want {
+			t.Errorf("%v: %s", f, got)
+		}
+	}
+	d := newDB(t, nil)
+	d.Write("c", "r", map[string]int{"a": 1})
+	_, e1, _ := d.ReadWithETag("c", "r")
+	d.WriteRaw("c", "r", []byte(`{ "a" : 1 }`))
+	e2, _ := d.ETag("c", "r")
+	if e1 != e2 || e1 == "" {
+		t.Fatal(e1, e2)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "encoding/json"
+
+// CollectionHandle is a driver bound to a single collection, for components
+// that only ever deal with one. Its methods forward to the Driver methods of
+// the same name.
+type CollectionHandle struct {
+	d    *Driver
+	name string
+}
+
+// Collection returns a handle on the named collection. The name is validated
+// by the operations, not here.
+func (d *Driver) Collection(name string) *CollectionHandle {
+	return &CollectionHandle{d: d, name: name}
+}
+
+// Name returns the collection the handle is bound to.
+func (c *CollectionHandle) Name() string {
+	return c.name
+}
+
+func (c *CollectionHandle) Write(resource string, v interface{}) error {
+	return c.d.Write(c.name, resource, v)
+}
+
+func (c *CollectionHandle) Create(resource string, v interface{}) error {
+	return c.d.Create(c.name, resource, v)
+}
+
+func (c *CollectionHandle) Update(resource string, fields map[string]interface{}) error {
+	return c.d.Update(c.name, resource, fields)
+}
+
+func (c *CollectionHandle) Read(resource string, v interface{}) error {
+	return c.d.Read(c.name, resource, v)
+}
+
+func (c *CollectionHandle) ReadRaw(resource string) (json.RawMessage, error) {
+	return c.d.ReadRaw(c.name, resource)
+}
+
+func (c *CollectionHandle) ReadAll() ([]string, error) {
+	return c.d.ReadAll(c.name)
+}
+
+func (c *CollectionHandle) ReadAllRaw() ([]json.RawMessage, error) {
+	return c.d.ReadAllRaw(c.name)
+}
+
+func (c *CollectionHandle) List() ([]string, error) {
+	return c.d.List(c.name)
+}
+
+func (c *CollectionHandle) Count() (int, error) {
+	return c.d.Count(c.name)
+}
+
+func (c *CollectionHandle) Delete(resource string) error {
+	return c.d.Delete(c.name, resource)
+}
+
+func (c *CollectionHandle) Drop() error {
+	return c.d.DropCollection(c.name)
+}
@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestCollection(t *testing.T) {
+	d := newDB(t, nil)
+	users := d.Collection("users")
+	if err := users.Write("a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	users.Update("a", map[string]interface{}{"m": 2})
+	var v map[string]int
+	if err := users.Read("a", &v); err != nil || v["m"] != 2 {
+		t.Fatal(v, err)
+	}
+	if all, _ := users.ReadAll(); len(all) != 1 {
+		t.Fatal(all)
+	}
+	if err := users.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := users.Count(); n != 0 {
+		t.Fatal(n)
+	}
+	if err := users.Drop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Increment adds by to the counter stored as the record resource, a bare JSON
+// integer, and returns the new value. A missing counter starts at zero. The
+// read and write happen under one collection lock, so concurrent increments
+// are never lost.
+func (d *Driver) Increment(collection string, resource string, by int64) (int64, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	n, err := d.readCounter(collection, resource)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	n += by
+
+	b, err := d.marshal(n)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.writeFile(collection, resource, b); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// readCounter reads the counter stored as the record resource; callers must
+// hold the collection lock.
+func (d *Driver) readCounter(collection string, resource string) (int64, error) {
+	b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	if err := json.Unmarshal(b, &n); err != nil {
+		return 0, fmt.Errorf("Invalid Counter '%s' - %v", filepath.Join(collection, resource), err)
+	}
+
+	return n, nil
+}
+
+// Counters is a set of durable counters kept as tiny records in one
+// collection, one record per counter.
+type Counters struct {
+	d          *Driver
+	collection string
+}
+
+// Counters returns the counters kept in collection.
+func (d *Driver) Counters(collection string) *Counters {
+	return &Counters{d: d, collection: collection}
+}
+
+// Inc adds by to the named counter and returns its new value.
+func (c *Counters) Inc(name string, by int64) (int64, error) {
+	return c.d.Increment(c.collection, name, by)
+}
+
+// Get returns the value of the named counter; one never incremented is zero.
+func (c *Counters) Get(name string) (int64, error) {
+	err := c.d.validateCollectionResource(c.collection, name)
+	if err != nil {
+		return 0, err
+	}
+
+	unlock, err := c.d.rlock(c.collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	n, err := c.d.readCounter(c.collection, name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	return n, err
+}
+
+// Reset sets the named counter back to zero.
+func (c *Counters) Reset(name string) error {
+	err := c.d.validateCollectionResource(c.collection, name)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.d.marshal(int64(0))
+	if err != nil {
+		return err
+	}
+
+	unlock, err := c.d.lock(c.collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return c.d.writeFile(c.collection, name, b)
+}
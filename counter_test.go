@@ -0,0 +1,34 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	d := newDB(t, nil)
+	c := d.Counters("ctr")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Inc("hits", 2); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n, err := c.Get("hits"); n != 100 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, err := c.Get("none"); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	if err := c.Reset("hits"); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := c.Inc("hits", -1); n != -1 {
+		t.Fatal(n)
+	}
+}
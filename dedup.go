@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Under Options.Dedup a record file holds only a pointer such as
+// {"$blob":"<sha256>"}; the body lives once per distinct content in the
+// collection's _blobs directory, named by its hash. Pointers are always plain
+// JSON: Options.CompressThreshold applies to the blob instead. Under
+// Options.Envelope the blob holds only the payload and the record file the
+// envelope around the pointer, so metadata never keeps equal payloads apart.
+const maxBlobPointer = 128
+
+var blobPointerKey = []byte(`"$blob"`)
+
+type blobRef struct {
+	Blob string `json:"$blob"`
+}
+
+func blobPointer(hash string) []byte {
+	return []byte(`{"$blob":"` + hash + `"}` + "\n")
+}
+
+// parseBlobPointer reports the hash b points at, if b is a blob pointer. The
+// pointer may be indented, as it is inside an envelope.
+func parseBlobPointer(b []byte) (string, bool) {
+	if len(b) > maxBlobPointer || !bytes.Contains(b, blobPointerKey) {
+		return "", false
+	}
+
+	var ref blobRef
+	if err := json.Unmarshal(b, &ref); err != nil || len(ref.Blob) != sha256.Size*2 {
+		return "", false
+	}
+
+	return ref.Blob, true
+}
+
+func blobPath(dir string, hash string) string {
+	return filepath.Join(dir, "_blobs", hash+".json")
+}
+
+// storeBlob stores b in the blob area of the collection directory dir, unless
+// identical content is already there, and returns the pointer to record in
+// its place.
+func (d *Driver) storeBlob(dir string, b []byte) ([]byte, error) {
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	switch path, _, err := resolveRecord(blobPath(dir, hash)); {
+	case os.IsNotExist(err):
+		if path, b, err = d.encodeRecord(path, b); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return nil, err
+		}
+		if err := d.retry(func() error { return os.WriteFile(path+".tmp", b, 0644) }); err != nil {
+			return nil, err
+		}
+		if err := d.retry(func() error { return os.Rename(path+".tmp", path) }); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	return blobPointer(hash), nil
+}
+
+// storeBlobFile is storeBlob for a body already written to tmpPath: the file
+// is moved into the blob area and replaced by the pointer.
+func (d *Driver) storeBlobFile(dir string, tmpPath string) error {
+	f, err := os.Open(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	switch path, _, err := resolveRecord(blobPath(dir, hash)); {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		path, encPath, err := d.encodeRecordFile(path, tmpPath)
+		if err != nil {
+			return err
+		}
+		if err := os.Rename(encPath, path); err != nil {
+			os.Remove(encPath)
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	return os.WriteFile(tmpPath, blobPointer(hash), 0644)
+}
+
+// storedRecord turns the payload b bound for fnlPath into what its record
+// file holds, returning that with the variant path to store it at: the blob
+// pointer under Options.Dedup, wrapped in its envelope under
+// Options.Envelope, and compressed if large enough. Callers must have
+// prepared the collection directory.
+func (d *Driver) storedRecord(collection string, fnlPath string, b []byte) (string, []byte, error) {
+	var err error
+	if d.opts.Dedup {
+		if b, err = d.storeBlob(d.collectionDir(collection), b); err != nil {
+			return "", nil, err
+		}
+	}
+
+	if b, err = d.wrapEnvelope(fnlPath, b); err != nil {
+		return "", nil, err
+	}
+
+	if d.opts.Dedup {
+		return fnlPath, b, nil
+	}
+
+	return d.encodeRecord(fnlPath, b)
+}
+
+// resolveBlob returns the body b points at when b, read from the record file
+// at path, is a blob pointer, and b itself otherwise. An envelope around a
+// pointer is returned around the body.
+func (d *Driver) resolveBlob(path string, b []byte) ([]byte, error) {
+	if !d.opts.Dedup {
+		return b, nil
+	}
+
+	if hash, ok := parseBlobPointer(b); ok {
+		return d.readBlob(d.collectionOf(path), hash)
+	}
+
+	meta, data := parseEnvelope(b)
+	hash, ok := parseBlobPointer(data)
+	if !ok {
+		return b, nil
+	}
+
+	data, err := d.readBlob(d.collectionOf(path), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.marshal(envelope{Meta: &meta, Data: json.RawMessage(data)})
+}
+
+// readBlob reads the blob hash of the collection directory dir.
+func (d *Driver) readBlob(dir string, hash string) ([]byte, error) {
+	path, format, err := resolveRecord(blobPath(dir, hash))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeRecord(b, format)
+}
+
+// readRecord reads the record stored at path, in whichever variant it is
+// stored (see resolveRecord), decoding it, following a blob pointer and
+// unwrapping its envelope.
+func (d *Driver) readRecord(path string) ([]byte, error) {
+	b, err := d.readStored(path)
+	if err != nil || !d.opts.Envelope {
+		return b, err
+	}
+
+	_, data := parseEnvelope(b)
+	return data, nil
+}
+
+// readStored is readRecord without unwrapping the envelope.
+func (d *Driver) readStored(path string) ([]byte, error) {
+	path, format, err := resolveRecord(path)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if b, err = decodeRecord(b, format); err != nil {
+		return nil, err
+	}
+
+	return d.resolveBlob(path, b)
+}
+
+// openRecord is readRecord for streaming: it returns a reader over the
+// decoded record.
+func (d *Driver) openRecord(path string) (io.ReadCloser, error) {
+	if d.opts.Envelope {
+		b, err := d.readRecord(path)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(bytes.NewReader(b)), nil
+	}
+
+	path, format, err := resolveRecord(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, format, err := d.openRecordFile(path, format)
+	if err != nil || format.decode == nil {
+		return f, err
+	}
+
+	r, err := format.decode(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return readCloser{Reader: r, close: func() error {
+		r.Close()
+		return f.Close()
+	}}, nil
+}
+
+// openRecordFile opens the record file at path, stored in format, or the blob
+// it points at, and returns the file with the format it is stored in.
+func (d *Driver) openRecordFile(path string, format recordFormat) (*os.File, recordFormat, error) {
+	f, err := os.Open(path)
+	if err != nil || !d.opts.Dedup {
+		return f, format, err
+	}
+
+	head := make([]byte, maxBlobPointer+1)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		f.Close()
+		return nil, format, err
+	}
+
+	if hash, ok := parseBlobPointer(head[:n]); ok {
+		f.Close()
+		if path, format, err = resolveRecord(blobPath(d.collectionOf(path), hash)); err != nil {
+			return nil, format, err
+		}
+		f, err = os.Open(path)
+		return f, format, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, format, err
+	}
+
+	return f, format, nil
+}
+
+// GCBlobs removes the blobs of collection that no record points at any more
+// and returns how many there were. Under Options.DryRun they are only counted.
+func (d *Driver) GCBlobs(collection string) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to collect blobs!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	blobs, err := os.ReadDir(filepath.Join(dir, "_blobs"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	entries, err := d.recordFiles(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	live := make(map[string]bool)
+	for _, e := range entries {
+		b, err := os.ReadFile(e.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		// Pointers written before blobs were compressed in their place may
+		// themselves be compressed.
+		_, format, _ := splitRecordName(e.path)
+		if b, err = decodeRecord(b, format); err != nil {
+			return 0, err
+		}
+
+		_, data := parseEnvelope(b)
+		if hash, ok := parseBlobPointer(data); ok {
+			live[hash] = true
+		}
+	}
+
+	var removed []string
+	for _, e := range blobs {
+		hash, _, ok := splitRecordName(e.Name())
+		if !ok || e.IsDir() || live[hash] {
+			continue
+		}
+
+		if !d.opts.DryRun {
+			if err := os.Remove(filepath.Join(dir, "_blobs", e.Name())); err != nil {
+				return len(removed), err
+			}
+		}
+		removed = append(removed, hash)
+	}
+
+	if d.opts.DryRun {
+		d.logDryRun("collect blobs of", collection, removed)
+	}
+
+	return len(removed), nil
+}
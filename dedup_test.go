@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDedup(t *testing.T) {
+	for _, mm := range []int64{0, 1} {
+		d := newDB(t, &Options{Dedup: true, MmapThreshold: mm})
+		v := map[string]string{"big": strings.Repeat("x", 200)}
+		d.Write("c", "a", v)
+		d.Write("c", "b", v)
+		d.WriteFrom("c", "s", strings.NewReader(`{"s":1}`))
+		blobs, _ := os.ReadDir(filepath.Join(d.dir, "c", "_blobs"))
+		if len(blobs) != 2 {
+			t.Fatal(len(blobs))
+		}
+		var got map[string]string
+		if err := d.Read("c", "b", &got); err != nil || got["big"] != v["big"] {
+			t.Fatal(err, got)
+		}
+		var s map[string]int
+		if err := d.ReadStream("c", "s", &s); err != nil || s["s"] != 1 {
+			t.Fatal(err, s)
+		}
+		all, _ := d.ReadAll("c")
+		if len(all) != 3 || !strings.Contains(all[0], "xxx") {
+			t.Fatal(all)
+		}
+		d.Update("c", "a", map[string]interface{}{"n": 1})
+		if n, err := d.GCBlobs("c"); n != 0 || err != nil {
+			t.Fatal(n, err)
+		}
+		d.Delete("c", "b")
+		if n, err := d.GCBlobs("c"); n != 1 || err != nil {
+			t.Fatal(n, err)
+		}
+		if raw, err := d.ReadRaw("c", "a"); err != nil || !strings.Contains(string(raw), `"n": 1`) {
+			t.Fatal(string(raw), err)
+		}
+		cs, _ := d.Collections()
+		if len(cs) != 1 {
+			t.Fatal(cs)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Meta is the metadata Options.Envelope keeps alongside every record.
+type Meta struct {
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+	Version int64     `json:"version"`
+}
+
+// envelope is the stored form of a record under Options.Envelope.
+type envelope struct {
+	Meta *Meta           `json:"meta"`
+	Data json.RawMessage `json:"data"`
+}
+
+// parseEnvelope splits a stored record into its metadata and payload. Records
+// written without Options.Envelope have no metadata and are their own payload.
+func parseEnvelope(b []byte) (Meta, json.RawMessage) {
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil || env.Meta == nil || env.Data == nil {
+		return Meta{}, b
+	}
+	return *env.Meta, env.Data
+}
+
+// wrapEnvelope wraps the payload b bound for fnlPath in an envelope, carrying
+// over the creation time and version of the record it replaces. Without
+// Options.Envelope b is returned as is.
+func (d *Driver) wrapEnvelope(fnlPath string, b []byte) ([]byte, error) {
+	if !d.opts.Envelope {
+		return b, nil
+	}
+
+	now := time.Now().UTC()
+	meta := Meta{Created: now}
+
+	switch old, err := d.readStored(fnlPath); {
+	case err == nil:
+		if prev, _ := parseEnvelope(old); !prev.Created.IsZero() {
+			meta = prev
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	meta.Updated = now
+	meta.Version++
+
+	return d.marshal(envelope{Meta: &meta, Data: json.RawMessage(b)})
+}
+
+// wrapEnvelopeFile is wrapEnvelope for a payload already written to tmpPath.
+func (d *Driver) wrapEnvelopeFile(fnlPath string, tmpPath string) error {
+	b, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if b, err = d.wrapEnvelope(fnlPath, b); err != nil {
+		return err
+	}
+
+	return os.WriteFile(tmpPath, b, 0644)
+}
+
+// touchEnvelope rewrites the envelope of the record file at path with its
+// Updated time set to now, keeping the payload, which under Options.Dedup
+// stays a blob pointer, and the version. A record written without Envelope
+// only has its modification time bumped.
+func (d *Driver) touchEnvelope(path string, now time.Time) error {
+	path, format, err := resolveRecord(path)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	if b, err = decodeRecord(b, format); err != nil {
+		return err
+	}
+
+	var env envelope
+	if err := json.Unmarshal(b, &env); err != nil || env.Meta == nil || env.Data == nil {
+		return os.Chtimes(path, now, now)
+	}
+
+	env.Meta.Updated = now.UTC()
+	if b, err = d.marshal(env); err != nil {
+		return err
+	}
+
+	fnlPath := trimRecordExt(path) + recordFormats[0].ext
+	if !d.opts.Dedup {
+		if fnlPath, b, err = d.encodeRecord(fnlPath, b); err != nil {
+			return err
+		}
+	}
+
+	tmpPath, err := d.writeTemp(fnlPath, b)
+	if err != nil {
+		return err
+	}
+
+	if err := d.commitFile(tmpPath, fnlPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return removeVariants(fnlPath)
+}
+
+// ReadEnvelope returns a record's payload together with the metadata
+// Options.Envelope keeps for it. Records written without Envelope have zero
+// metadata.
+func (d *Driver) ReadEnvelope(collection string, resource string) (Meta, json.RawMessage, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	defer unlock()
+
+	b, err := d.readStored(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		return Meta{}, nil, recordError(collection, resource, err)
+	}
+
+	meta, data := parseEnvelope(b)
+	return meta, data, nil
+}
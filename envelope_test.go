@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvelope(t *testing.T) {
+	d := newDB(t, &Options{Envelope: true})
+	if err := d.Write("u", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]int
+	if err := d.Read("u", "a", &v); err != nil || v["n"] != 1 {
+		t.Fatal(v, err)
+	}
+	m1, data, err := d.ReadEnvelope("u", "a")
+	if err != nil || m1.Version != 1 || m1.Created.IsZero() || !strings.Contains(string(data), `"n": 1`) {
+		t.Fatal(m1, string(data), err)
+	}
+	d.Write("u", "a", map[string]int{"n": 2})
+	m2, _, _ := d.ReadEnvelope("u", "a")
+	if m2.Version != 2 || !m2.Created.Equal(m1.Created) {
+		t.Fatal(m2)
+	}
+	if err := d.WriteFrom("u", "b", strings.NewReader(`{"x":3}`)); err != nil {
+		t.Fatal(err)
+	}
+	all, err := d.ReadAll("u")
+	if err != nil || len(all) != 2 || strings.Contains(all[1], "meta") || !strings.Contains(all[1], `"x"`) {
+		t.Fatal(all, err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(d.dir, "u", "a.json"))
+	if !strings.Contains(string(raw), `"meta"`) {
+		t.Fatal(string(raw))
+	}
+	if _, _, err := d.ReadEnvelope("u", "zz"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestEnvelopeVariants(t *testing.T) {
+	for _, threshold := range []int{0, 10} {
+		d := newDB(t, &Options{Dedup: true, Envelope: true, CompressThreshold: threshold})
+		body := map[string]string{"v": strings.Repeat("x", 50)}
+		for _, r := range []string{"a", "b"} {
+			if err := d.Write("c", r, body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		raw, _ := d.marshal(body)
+		if err := d.WriteFrom("c", "s", bytes.NewReader(raw)); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Write("c", "a", body); err != nil {
+			t.Fatal(err)
+		}
+		blobs, _ := os.ReadDir(filepath.Join(d.dir, "c", "_blobs"))
+		if len(blobs) != 1 {
+			t.Fatalf("%d blobs", len(blobs))
+		}
+		var v map[string]string
+		for _, r := range []string{"a", "b", "s"} {
+			if err := d.Read("c", r, &v); err != nil || v["v"] != body["v"] {
+				t.Fatalf("%s: %v %v", r, v, err)
+			}
+		}
+		meta, data, err := d.ReadEnvelope("c", "a")
+		if err != nil || meta.Version != 2 || !strings.Contains(string(data), "xxx") {
+			t.Fatalf("envelope %+v %s %v", meta, data, err)
+		}
+		if n, err := d.GCBlobs("c"); err != nil || n != 0 {
+			t.Fatalf("gc %d %v", n, err)
+		}
+		all, err := d.ReadAll("c")
+		if err != nil || len(all) != 3 {
+			t.Fatalf("all %v", err)
+		}
+	}
+}
+
+func TestTouchEnvelope(t *testing.T) {
+	for _, o := range []*Options{{Envelope: true}, {Envelope: true, Dedup: true}, {Envelope: true, CompressThreshold: 5}} {
+		d := newDB(t, o)
+		d.Write("c", "a", map[string]int{"v": 1})
+		m1, _, _ := d.ReadEnvelope("c", "a")
+		time.Sleep(5 * time.Millisecond)
+		if err := d.Touch("c", "a"); err != nil {
+			t.Fatal(err)
+		}
+		m2, data, err := d.ReadEnvelope("c", "a")
+		if err != nil || !m2.Updated.After(m1.Updated) || m2.Version != m1.Version || !m2.Created.Equal(m1.Created) {
+			t.Fatalf("%+v %+v %v", m1, m2, err)
+		}
+		var v map[string]int
+		if err := d.Read("c", "a", &v); err != nil || v["v"] != 1 {
+			t.Fatal(string(data), err)
+		}
+	}
+	d := newDB(t, &Options{Envelope: true})
+	if err := d.Touch("c", "nope"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+}
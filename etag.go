@@ -0,0 +1,96 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ReadWithETag returns a record's stored bytes together with an ETag for it:
+// the hex SHA-256 of the record's canonical form, so it changes whenever the
+// record's content does but not when only its formatting does.
+func (d *Driver) ReadWithETag(collection string, resource string) (json.RawMessage, string, error) {
+	b, err := d.ReadRaw(collection, resource)
+	if err != nil {
+		return nil, "", err
+	}
+
+	etag, err := etagOf(b)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return b, etag, nil
+}
+
+// ETag computes a record's ETag without handing back its body.
+func (d *Driver) ETag(collection string, resource string) (string, error) {
+	_, etag, err := d.ReadWithETag(collection, resource)
+	return etag, err
+}
+
+func etagOf(b []byte) (string, error) {
+	c, err := Canonicalize(json.RawMessage(b))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(c)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// CollectionHash returns a hash of the whole content of collection: the hex
+// SHA-256 over its resource names and their ETags, in name order. Copies with
+// the same records hash alike however and whenever they were written, so
+// comparing hashes verifies replication.
+func (d *Driver) CollectionHash(collection string) (string, error) {
+	if collection == "" {
+		return "", errors.New("Missing Collection - unable to hash!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return "", err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	files, err := d.recordFiles(d.collectionDir(collection))
+	if err != nil && !d.missingAsEmpty(err) {
+		return "", err
+	}
+
+	etags := make(map[string]string, len(files))
+	resources := make([]string, 0, len(files))
+
+	for _, f := range files {
+		b, err := d.readRecord(f.path)
+		if err != nil {
+			return "", err
+		}
+
+		etag, err := etagOf(b)
+		if err != nil {
+			return "", fmt.Errorf("Unable to hash '%s': %w", f.path, err)
+		}
+
+		resource := d.decodeKey(trimRecordExt(f.Name()))
+		etags[resource] = etag
+		resources = append(resources, resource)
+	}
+
+	sort.Strings(resources)
+
+	h := sha256.New()
+	for _, resource := range resources {
+		fmt.Fprintf(h, "%s\x00%s\n", resource, etags[resource])
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
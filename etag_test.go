@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadWithETag(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	_, e1, err := d.ReadWithETag("c", "a")
+	e1b, _ := d.ETag("c", "a")
+	d.Write("c", "a", 2)
+	e2, _ := d.ETag("c", "a")
+	if err != nil || e1 != e1b || e1 == e2 || len(e1) != 64 {
+		t.Fatal(e1, e1b, e2, err)
+	}
+	if _, err := d.ETag("c", "z"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectionHash(t *testing.T) {
+	a := newDB(t, nil)
+	b := newDB(t, &Options{CompressThreshold: 1})
+	a.Write("u", "x", map[string]int{"a": 1, "b": 2})
+	a.Write("u", "y", 3)
+	b.Write("u", "y", 3)
+	b.WriteRaw("u", "x", []byte(`{"b":2,"a":1}`))
+	ha, err := a.CollectionHash("u")
+	hb, _ := b.CollectionHash("u")
+	if err != nil || ha != hb {
+		t.Fatal(ha, hb, err)
+	}
+	b.Write("u", "y", 4)
+	if hb, _ = b.CollectionHash("u"); ha == hb {
+		t.Fatal("hash unchanged after a write")
+	}
+}
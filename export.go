@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ImportStrategy decides what Import does with records that already exist.
+type ImportStrategy int
+
+const (
+	ImportOverwrite ImportStrategy = iota
+	ImportSkip
+	ImportError
+)
+
+// ExportResources writes a JSON object mapping each requested resource to its
+// stored record. Record bytes are copied straight from disk, not re-marshaled.
+// Resources that don't exist are left out of the object.
+func (d *Driver) ExportResources(collection string, resources []string, w io.Writer) error {
+	for _, resource := range resources {
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := io.WriteString(w, "{"); err != nil {
+		return err
+	}
+
+	first := true
+	for _, resource := range resources {
+		f, err := d.openRecord(d.recordPath(collection, resource) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = exportEntry(w, resource, f, first)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		first = false
+	}
+
+	_, err = io.WriteString(w, "}")
+	return err
+}
+
+func exportEntry(w io.Writer, resource string, r io.Reader, first bool) error {
+	key, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+
+	if !first {
+		key = append([]byte(","), key...)
+	}
+
+	if _, err := w.Write(append(key, ':')); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Import reads a JSON object of resource -> record, as produced by
+// ExportResources, and stores each record under the collection lock. Existing
+// records are handled according to strategy; with ImportError the import stops
+// at the first conflict, leaving the records written before it in place.
+func (d *Driver) Import(collection string, r io.Reader, strategy ImportStrategy) (imported int, skipped int, err error) {
+	var records map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return 0, 0, err
+	}
+
+	resources := make([]string, 0, len(records))
+	for resource := range records {
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			return 0, 0, err
+		}
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	for _, resource := range resources {
+		_, _, err := resolveRecord(d.recordPath(collection, resource) + ".json")
+		exists := err == nil
+
+		switch {
+		case err != nil && !os.IsNotExist(err):
+			return imported, skipped, err
+		case exists && strategy == ImportSkip:
+			skipped++
+			continue
+		case exists && strategy == ImportError:
+			return imported, skipped, fmt.Errorf("%w '%s'", ErrAlreadyExists, filepath.Join(collection, resource))
+		}
+
+		if err := d.writeFile(collection, resource, records[resource]); err != nil {
+			return imported, skipped, err
+		}
+		imported++
+	}
+
+	return imported, skipped, nil
+}
+
+// StreamAll writes every record in collection to w as one JSON array, copying
+// each record straight from its file so the collection is never held in
+// memory at once.
+func (d *Driver) StreamAll(collection string, w io.Writer) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	resources, err := d.listResources(d.collectionDir(collection))
+	if err != nil && !d.missingAsEmpty(err) {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+
+	first := true
+	for _, resource := range resources {
+		f, err := d.openRecord(d.recordPath(collection, resource) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				f.Close()
+				return err
+			}
+		}
+
+		_, err = io.Copy(w, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		first = false
+	}
+
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// ExportJSONL writes every record in collection to w as JSON Lines: one
+// compact record per line, in resource order.
+func (d *Driver) ExportJSONL(collection string, w io.Writer) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to export!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	resources, err := d.listResources(d.collectionDir(collection))
+	if err != nil && !d.missingAsEmpty(err) {
+		return err
+	}
+
+	var line bytes.Buffer
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		line.Reset()
+		if err := json.Compact(&line, b); err != nil {
+			return fmt.Errorf("Unable to export '%s': %w", filepath.Join(collection, resource), err)
+		}
+		line.WriteByte('\n')
+
+		if _, err := w.Write(line.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportJSONL reads JSON Lines from r and stores each record under the value
+// of its keyField, which may be dotted to reach into nested objects and must
+// be a string or a number. Existing records are overwritten. It returns how
+// many records were written before any error.
+func (d *Driver) ImportJSONL(collection string, r io.Reader, keyField string) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to import!")
+	}
+
+	if keyField == "" {
+		return 0, errors.New("Missing Key Field - unable to name imported records!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dec := json.NewDecoder(r)
+	imported := 0
+
+	for line := 1; ; line++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			return imported, nil
+		} else if err != nil {
+			return imported, fmt.Errorf("Invalid JSON on line %d - %w", line, err)
+		}
+
+		resource, err := jsonlKey(raw, keyField)
+		if err != nil {
+			return imported, fmt.Errorf("Unable to import line %d: %w", line, err)
+		}
+
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			return imported, err
+		}
+
+		b, err := d.marshal(raw)
+		if err != nil {
+			return imported, err
+		}
+
+		if err := d.writeFile(collection, resource, b); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+}
+
+// jsonlKey extracts the resource name stored at keyField in raw.
+func jsonlKey(raw json.RawMessage, keyField string) (string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+
+	var record interface{}
+	if err := dec.Decode(&record); err != nil {
+		return "", err
+	}
+
+	switch key, _ := lookupField(record, keyField); key := key.(type) {
+	case string:
+		return key, nil
+	case json.Number:
+		return key.String(), nil
+	}
+
+	return "", fmt.Errorf("key field '%s' is missing or not a string or number", keyField)
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExportResources(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", User{Name: "A"})
+	d.Write("c", "b", User{Name: "B"})
+	d.Write("c", "z", User{Name: "Z"})
+	var buf bytes.Buffer
+	if err := d.ExportResources("c", []string{"a", "missing", "b"}, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]User
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil || len(m) != 2 || m["b"].Name != "B" {
+		t.Fatal(err, m, buf.String())
+	}
+}
+
+func TestImport(t *testing.T) {
+	in := `{"a":{"v":10},"b":{"v":20},"c":{"v":30}}`
+	for _, tc := range []struct {
+		s        ImportStrategy
+		imp, skp int
+		err      bool
+		a        int
+	}{{ImportOverwrite, 3, 0, false, 10}, {ImportSkip, 2, 0 + 1, false, 1}, {ImportError, 0, 0, true, 1}} {
+		d := newDB(t, nil)
+		d.Write("c", "a", map[string]int{"v": 1})
+		i, s, err := d.Import("c", strings.NewReader(in), tc.s)
+		if i != tc.imp || s != tc.skp || (err != nil) != tc.err {
+			t.Fatal(tc, i, s, err)
+		}
+		var m map[string]int
+		d.Read("c", "a", &m)
+		if m["v"] != tc.a {
+			t.Fatal(m)
+		}
+	}
+}
+
+func TestExportJSONL(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("u", "1", map[string]interface{}{"id": 1, "name": "a"})
+	d.Write("u", "bob", map[string]interface{}{"id": "bob", "meta": map[string]int{"x": 1}})
+	var buf bytes.Buffer
+	if err := d.ExportJSONL("u", &buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"id\":1,\"name\":\"a\"}\n{\"id\":\"bob\",\"meta\":{\"x\":1}}\n"
+	if buf.String() != want {
+		t.Fatalf("%q", buf.String())
+	}
+	n, err := d.ImportJSONL("v", &buf, "id")
+	if n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	a, _ := d.ReadAllWithKeys("u")
+	b, _ := d.ReadAllWithKeys("v")
+	if !reflect.DeepEqual(a, b) {
+		t.Fatal(a, b)
+	}
+	n, err = d.ImportJSONL("w", strings.NewReader("{\"id\":\"x\"}\n{\"nokey\":1}\n"), "id")
+	if n != 1 || err == nil {
+		t.Fatal(n, err)
+	}
+}
+
+func TestStreamAll(t *testing.T) {
+	d := newDB(t, nil)
+	d.PrepareCollection("e")
+	var buf bytes.Buffer
+	if err := d.StreamAll("e", &buf); err != nil || buf.String() != "[]" {
+		t.Fatal(buf.String(), err)
+	}
+	d.Write("c", "a", map[string]int{"n": 1})
+	d.Write("c", "b", map[string]int{"n": 2})
+	buf.Reset()
+	d.StreamAll("c", &buf)
+	var out []map[string]int
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil || len(out) != 2 || out[1]["n"] != 2 {
+		t.Fatal(out, err)
+	}
+	if err := d.StreamAll("missing", &buf); err == nil {
+		t.Fatal("no error")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// recordFormat is one way a record can be stored on disk: the extension of
+// its file and, unless the file holds plain JSON, how to decode and encode it.
+type recordFormat struct {
+	ext    string
+	decode func(io.Reader) (io.ReadCloser, error)
+	encode func(io.Writer) io.WriteCloser
+}
+
+// recordFormats are the stored variants a record is looked up in, in order.
+var recordFormats = []recordFormat{
+	{ext: ".json"},
+	{
+		ext:    ".json.gz",
+		decode: func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+		encode: func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+	},
+}
+
+// compressedFormat is the variant records above Options.CompressThreshold
+// are stored in.
+var compressedFormat = recordFormats[1]
+
+// splitRecordName splits a record file name or path into the part before its
+// extension and its format. ok is false for anything else.
+func splitRecordName(name string) (base string, format recordFormat, ok bool) {
+	for _, format := range recordFormats {
+		if base, ok := strings.CutSuffix(name, format.ext); ok {
+			return base, format, true
+		}
+	}
+	return name, recordFormat{}, false
+}
+
+// trimRecordExt strips the record extension from a file name.
+func trimRecordExt(name string) string {
+	base, _, _ := splitRecordName(name)
+	return base
+}
+
+// resolveRecord finds the file holding the record stored at path, which names
+// any variant of the record, extension included, and returns it with its
+// format. Only that one extension is stripped, so a resource whose own name
+// ends in .json never resolves to another record. When no variant exists the
+// error satisfies os.IsNotExist and the path of the plain .json variant is
+// returned, for callers that want to create it.
+func resolveRecord(path string) (string, recordFormat, error) {
+	base, _, _ := splitRecordName(path)
+
+	for _, format := range recordFormats {
+		_, err := os.Stat(base + format.ext)
+		if err == nil {
+			return base + format.ext, format, nil
+		}
+		if !os.IsNotExist(err) {
+			return base + format.ext, format, err
+		}
+	}
+
+	path = base + recordFormats[0].ext
+	return path, recordFormats[0], &fs.PathError{Op: "stat", Path: path, Err: fs.ErrNotExist}
+}
+
+// decodeRecord turns a stored record's bytes back into JSON.
+func decodeRecord(b []byte, format recordFormat) ([]byte, error) {
+	if format.decode == nil {
+		return b, nil
+	}
+
+	r, err := format.decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// encodeRecord picks the stored variant for the record b bound for fnlPath,
+// returning the path to write and the bytes to write there: records larger
+// than Options.CompressThreshold are compressed.
+func (d *Driver) encodeRecord(fnlPath string, b []byte) (string, []byte, error) {
+	if d.opts.CompressThreshold <= 0 || len(b) <= d.opts.CompressThreshold {
+		return fnlPath, b, nil
+	}
+
+	var buf bytes.Buffer
+	w := compressedFormat.encode(&buf)
+	if _, err := w.Write(b); err != nil {
+		return "", nil, err
+	}
+	if err := w.Close(); err != nil {
+		return "", nil, err
+	}
+
+	return trimRecordExt(fnlPath) + compressedFormat.ext, buf.Bytes(), nil
+}
+
+// encodeRecordFile is encodeRecord for a record already written to tmpPath.
+// A compressed record goes to a new temp file, and tmpPath is removed.
+func (d *Driver) encodeRecordFile(fnlPath string, tmpPath string) (string, string, error) {
+	fi, err := os.Stat(tmpPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	if d.opts.CompressThreshold <= 0 || fi.Size() <= int64(d.opts.CompressThreshold) {
+		return fnlPath, tmpPath, nil
+	}
+
+	fnlPath = trimRecordExt(fnlPath) + compressedFormat.ext
+
+	src, err := os.Open(tmpPath)
+	if err != nil {
+		return "", "", err
+	}
+	defer src.Close()
+
+	dst, err := createTemp(fnlPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	w := compressedFormat.encode(dst)
+	_, err = io.Copy(w, src)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst.Name())
+		return "", "", err
+	}
+
+	os.Remove(tmpPath)
+	return fnlPath, dst.Name(), nil
+}
+
+// removeVariants removes every stored variant of the record at path except
+// path itself, so a rewritten record never leaves a stale copy behind.
+func removeVariants(path string) error {
+	base, _, _ := splitRecordName(path)
+
+	for _, format := range recordFormats {
+		if base+format.ext == path {
+			continue
+		}
+		if err := os.Remove(base + format.ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readCloser pairs a decoding reader with closing the file underneath it.
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGz stores s gzip-compressed at path, as an external tool would.
+func writeGz(t testing.TB, path string, s string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressedFallback(t *testing.T) {
+	d := newDB(t, &Options{MmapThreshold: 1})
+	d.Write("c", "plain", map[string]int{"n": 1})
+	writeGz(t, filepath.Join(d.dir, "c", "z.json.gz"), `{"n":2}`)
+	var v map[string]int
+	if err := d.Read("c", "z", &v); err != nil || v["n"] != 2 {
+		t.Fatal(v, err)
+	}
+	v = nil
+	if err := d.ReadStream("c", "z", &v); err != nil || v["n"] != 2 {
+		t.Fatal(v, err)
+	}
+	all, _ := d.ReadAll("c")
+	names, _ := d.List("c")
+	if len(all) != 2 || all[1] != `{"n":2}` || names[1] != "z" {
+		t.Fatal(all, names)
+	}
+	var buf bytes.Buffer
+	d.StreamAll("c", &buf)
+	if !json.Valid(buf.Bytes()) {
+		t.Fatal(buf.String())
+	}
+	if err := d.Swap("c", "plain", "z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "c", "plain.json.gz")); err != nil {
+		t.Fatal(err)
+	}
+	d.Read("c", "plain", &v)
+	if v["n"] != 2 {
+		t.Fatal(v)
+	}
+	if err := d.Delete("c", "plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("c", "plain", &v); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	if err := d.Write("c/z", "x", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+}
+
+func TestCompressThreshold(t *testing.T) {
+	d := newDB(t, &Options{CompressThreshold: 100})
+	d.Write("u", "small", map[string]int{"a": 1})
+	big := map[string]string{"s": strings.Repeat("x", 500)}
+	d.Write("u", "big", big)
+	if _, err := os.Stat(filepath.Join(d.dir, "u", "small.json")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "u", "big.json.gz")); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := d.Read("u", "big", &got); err != nil || got["s"] != big["s"] {
+		t.Fatal(err)
+	}
+	// Shrinking the record replaces the compressed variant.
+	d.Write("u", "big", map[string]int{"a": 2})
+	ents, _ := os.ReadDir(filepath.Join(d.dir, "u"))
+	if len(ents) != 2 {
+		t.Fatal(ents)
+	}
+	if err := d.WriteFrom("u", "s", strings.NewReader(`"`+strings.Repeat("y", 300)+`"`)); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := d.Read("u", "s", &s); err != nil || len(s) != 300 {
+		t.Fatal(err)
+	}
+	if err := d.Create("u", "s", 1); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatal(err)
+	}
+	if err := d.WriteBatchAtomic("u", map[string]interface{}{"s": 1, "t": big}); err != nil {
+		t.Fatal(err)
+	}
+	ents, _ = os.ReadDir(filepath.Join(d.dir, "u"))
+	if len(ents) != 4 {
+		t.Fatal(ents)
+	}
+	if n, _ := d.Count("u"); n != 4 {
+		t.Fatal(n)
+	}
+}
+
+func TestResourceWithJSONSuffix(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Write("c", "a", map[string]int{"v": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := d.Exists("c", "a.json"); err != nil || ok {
+		t.Fatalf("Exists a.json = %v, %v", ok, err)
+	}
+	var v map[string]int
+	if err := d.Read("c", "a.json", &v); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("Read a.json = %v", err)
+	}
+	if err := d.Delete("c", "a.json"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatalf("Delete a.json = %v", err)
+	}
+	if ok, _ := d.Exists("c", "a"); !ok {
+		t.Fatal("a was removed")
+	}
+	if err := d.Write("c", "a.json", map[string]int{"v": 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Read("c", "a", &v); err != nil || v["v"] != 1 {
+		t.Fatalf("a = %v, %v", v, err)
+	}
+	if err := d.Read("c", "a.json", &v); err != nil || v["v"] != 2 {
+		t.Fatalf("a.json = %v, %v", v, err)
+	}
+	if err := d.Delete("c", "a.json"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.Exists("c", "a"); !ok {
+		t.Fatal("a was removed")
+	}
+}
+
+func TestCompressThresholdVariants(t *testing.T) {
+	d := newDB(t, &Options{Dedup: true, CompressThreshold: 10})
+	big := map[string]string{"v": strings.Repeat("x", 200)}
+	for _, r := range []string{"a", "b"} {
+		if err := d.Write("c", r, big); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.WriteFrom("c", "s", strings.NewReader(`{"v":"`+strings.Repeat("y", 200)+`"}`)); err != nil {
+		t.Fatal(err)
+	}
+	n, err := d.GCBlobs("c")
+	if err != nil || n != 0 {
+		t.Fatalf("GCBlobs = %d, %v", n, err)
+	}
+	blobs, _ := os.ReadDir(filepath.Join(d.dir, "c", "_blobs"))
+	for _, b := range blobs {
+		if !strings.HasSuffix(b.Name(), ".json.gz") {
+			t.Fatalf("blob %s not compressed", b.Name())
+		}
+	}
+	if len(blobs) != 2 {
+		t.Fatalf("%d blobs", len(blobs))
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "c", "a.json")); err != nil {
+		t.Fatal("pointer compressed:", err)
+	}
+	var v map[string]string
+	for _, r := range []string{"a", "b"} {
+		if err := d.Read("c", r, &v); err != nil || v["v"] != big["v"] {
+			t.Fatalf("%s: %v", r, err)
+		}
+	}
+	if err := d.ReadStream("c", "s", &v); err != nil || len(v["v"]) != 200 {
+		t.Fatalf("stream = %v, %v", v, err)
+	}
+	if err := d.Delete("c", "a"); err != nil {
+		t.Fatal(err)
+	}
+	d.Delete("c", "b")
+	if n, err := d.GCBlobs("c"); err != nil || n != 1 {
+		t.Fatalf("GCBlobs = %d, %v", n, err)
+	}
+}
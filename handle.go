@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// RecordFile is a read-only handle on a record file, returned by OpenRecord.
+// It holds the collection's read lock until Close, so writers to the
+// collection wait while it is open: close it promptly, and never write to
+// the same collection before closing it.
+type RecordFile struct {
+	*os.File
+
+	once   sync.Once
+	unlock func()
+}
+
+// Close closes the file and releases the collection lock. Further calls are
+// no-ops.
+func (f *RecordFile) Close() error {
+	err := os.ErrClosed
+	f.once.Do(func() {
+		err = f.File.Close()
+		f.unlock()
+	})
+	return err
+}
+
+// OpenRecord opens a record file for callers that want to range-read or map it
+// themselves. The file is returned as stored, so a compressed record reads as
+// gzip data. See RecordFile for the locking contract.
+func (d *Driver) OpenRecord(collection string, resource string) (*RecordFile, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	path, format, err := resolveRecord(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		unlock()
+		return nil, recordError(collection, resource, err)
+	}
+
+	f, _, err := d.openRecordFile(path, format)
+	if err != nil {
+		unlock()
+		return nil, recordError(collection, resource, err)
+	}
+
+	return &RecordFile{File: f, unlock: unlock}, nil
+}
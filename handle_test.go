@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestOpenRecord(t *testing.T) {
+	d := newDB(t, &Options{LockTimeout: 20 * time.Millisecond})
+	d.Write("c", "a", map[string]int{"n": 1})
+	f, err := d.OpenRecord("c", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 4)
+	f.ReadAt(b, 3)
+	if string(b) != "\"n\":" {
+		t.Fatalf("%q", b)
+	}
+	if err := d.Write("c", "b", 1); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err == nil {
+		t.Fatal("double close")
+	}
+	if err := d.Write("c", "b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.OpenRecord("c", "zz"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "b", 1); err != nil {
+		t.Fatal(err)
+	}
+}
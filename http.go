@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// Handler exposes the driver as a small REST API:
+//
+//	GET    /{collection}             list the records in a collection
+//	GET    /{collection}/{resource}  read a record
+//	PUT    /{collection}/{resource}  write a record from the request body
+//	DELETE /{collection}/{resource}  delete a record
+//
+// Nested collections work too; since /a/b could name either a record or a
+// collection, a trailing slash (/a/b/) asks for the collection listing.
+// Nothing is served unless the application mounts the handler itself.
+func Handler(d *Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/")
+
+		if path == "" {
+			http.Error(w, "missing collection", http.StatusNotFound)
+			return
+		}
+
+		if strings.HasSuffix(path, "/") || !strings.Contains(path, "/") {
+			collection := strings.TrimSuffix(path, "/")
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			serveList(d, w, collection)
+			return
+		}
+
+		i := strings.LastIndex(path, "/")
+		collection, resource := path[:i], path[i+1:]
+
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			serveRecord(d, w, r, collection, resource)
+		case http.MethodPut:
+			storeRecord(d, w, r, collection, resource)
+		case http.MethodDelete:
+			if err := d.Delete(collection, resource); err != nil {
+				httpError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, PUT, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func serveList(d *Driver, w http.ResponseWriter, collection string) {
+	records, err := d.ReadAllRaw(collection)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	b, err := json.Marshal(records)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func serveRecord(d *Driver, w http.ResponseWriter, r *http.Request, collection string, resource string) {
+	b, etag, err := d.ReadWithETag(collection, resource)
+	if err != nil {
+		httpError(w, err)
+		return
+	}
+
+	etag = `"` + etag + `"`
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func storeRecord(d *Driver, w http.ResponseWriter, r *http.Request, collection string, resource string) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !json.Valid(b) {
+		http.Error(w, "request body is not valid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := d.WriteRaw(collection, resource, b); err != nil {
+		httpError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func httpError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+
+	switch {
+	case errors.Is(err, ErrRecordNotFound), errors.Is(err, fs.ErrNotExist):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrConflict), errors.Is(err, ErrAlreadyExists),
+		errors.Is(err, ErrImmutable), errors.Is(err, ErrCollectionFull):
+		status = http.StatusConflict
+	case errors.Is(err, ErrInvalidCollection), errors.Is(err, ErrInvalidResource):
+		status = http.StatusBadRequest
+	case errors.Is(err, ErrFieldType):
+		status = http.StatusUnprocessableEntity
+	case errors.Is(err, ErrLockTimeout), errors.Is(err, ErrTimeout):
+		status = http.StatusServiceUnavailable
+	}
+
+	http.Error(w, err.Error(), status)
+}
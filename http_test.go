@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	d := newDB(t, nil)
+	srv := httptest.NewServer(Handler(d))
+	defer srv.Close()
+	do := func(m, p, body string, h ...string) (*http.Response, string) {
+		req, _ := http.NewRequest(m, srv.URL+p, strings.NewReader(body))
+		if len(h) == 2 {
+			req.Header.Set(h[0], h[1])
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		return res, string(b)
+	}
+	if r, _ := do("PUT", "/user/a", `{"Name":"A"}`); r.StatusCode != 204 {
+		t.Fatal(r.Status)
+	}
+	if r, _ := do("PUT", "/user/a", `{`); r.StatusCode != 400 {
+		t.Fatal(r.Status)
+	}
+	r, b := do("GET", "/user/a", "")
+	if r.StatusCode != 200 || b != `{"Name":"A"}` || r.Header.Get("Content-Type") != "application/json" {
+		t.Fatal(r.Status, b)
+	}
+	if r2, _ := do("GET", "/user/a", "", "If-None-Match", r.Header.Get("ETag")); r2.StatusCode != 304 {
+		t.Fatal(r2.Status)
+	}
+	if r, b := do("GET", "/user", ""); r.StatusCode != 200 || b != `[{"Name":"A"}]` {
+		t.Fatal(r.Status, b)
+	}
+	if r, _ := do("GET", "/user/zz", ""); r.StatusCode != 404 {
+		t.Fatal(r.Status)
+	}
+	if r, _ := do("GET", "/nope", ""); r.StatusCode != 404 {
+		t.Fatal(r.Status)
+	}
+	d.Write("user/sub", "x", 1)
+	if r, _ := do("PUT", "/user/sub", `1`); r.StatusCode != 409 {
+		t.Fatal(r.Status)
+	}
+	if r, _ := do("DELETE", "/user/a", ""); r.StatusCode != 204 {
+		t.Fatal(r.Status)
+	}
+	if r, _ := do("DELETE", "/user/a", ""); r.StatusCode != 404 {
+		t.Fatal(r.Status)
+	}
+}
+
+func TestHTTPErrorStatus(t *testing.T) {
+	cases := map[error]int{
+		ErrImmutable:                               409,
+		fmt.Errorf("x: %w", ErrCollectionFull):     409,
+		fmt.Errorf("%w 'x'", ErrFieldType):         422,
+		fmt.Errorf("%w 'x'", ErrInvalidResource):   400,
+		fmt.Errorf("%w 'x'", ErrInvalidCollection): 400,
+	}
+	for err, want := range cases {
+		w := httptest.NewRecorder()
+		httpError(w, err)
+		if w.Code != want {
+			t.Fatal(err, w.Code)
+		}
+	}
+	d := newDB(t, nil)
+	if err := d.Write("c", "_x", 1); !errors.Is(err, ErrInvalidResource) {
+		t.Fatal(err)
+	}
+}
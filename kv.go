@@ -0,0 +1,28 @@
+package main
+
+// defaultCollection is the DefaultCollection when none is configured.
+const defaultCollection = "_kv"
+
+// Set stores v under key in the default collection, for plain key-value use
+// without thinking about collections. See Options.DefaultCollection.
+func (d *Driver) Set(key string, v interface{}) error {
+	return d.Write(d.kvCollection(), key, v)
+}
+
+// GetInto reads the value stored under key by Set into v, failing with
+// ErrRecordNotFound if there is none.
+func (d *Driver) GetInto(key string, v interface{}) error {
+	return d.Read(d.kvCollection(), key, v)
+}
+
+// Remove deletes the value stored under key by Set.
+func (d *Driver) Remove(key string) error {
+	return d.Delete(d.kvCollection(), key)
+}
+
+func (d *Driver) kvCollection() string {
+	if d.opts.DefaultCollection != "" {
+		return d.opts.DefaultCollection
+	}
+	return defaultCollection
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultCollection(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users", "a", User{Name: "U"})
+	if err := d.Set("a", "kv"); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if err := d.GetInto("a", &s); err != nil || s != "kv" {
+		t.Fatal(s, err)
+	}
+	var u User
+	if d.Read("users", "a", &u); u.Name != "U" {
+		t.Fatal(u)
+	}
+	if cs, _ := d.Collections(); len(cs) != 1 || cs[0] != "users" {
+		t.Fatal(cs)
+	}
+	if err := d.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.GetInto("a", &s); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	d2 := newDB(t, &Options{DefaultCollection: "settings"})
+	d2.Set("theme", "dark")
+	if err := d2.Read("settings", "theme", &s); err != nil || s != "dark" {
+		t.Fatal(err)
+	}
+}
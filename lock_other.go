@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func lockDir(dir string, breakStale bool) (*os.File, error) {
+	return nil, errors.New("Exclusive Lock - not supported on this platform!")
+}
+
+func unlockDir(f *os.File) error {
+	return f.Close()
+}
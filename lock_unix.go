@@ -0,0 +1,96 @@
+//go:build unix
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// lockHolder is written to the _lock file by the process holding it, so a
+// lock outliving its holder can be recognized.
+type lockHolder struct {
+	PID     int
+	Host    string
+	Started time.Time
+}
+
+func lockDir(dir string, breakStale bool) (*os.File, error) {
+	path := filepath.Join(dir, "_lock")
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, err
+		}
+
+		// A lock can't be taken from its holder, but a fresh lock file can
+		// replace one whose holder is gone.
+		if breakStale && staleLock(path) {
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+			return lockDir(dir, false)
+		}
+
+		return nil, ErrLocked
+	}
+
+	if err := writeLockHolder(f); err != nil {
+		unlockDir(f)
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func unlockDir(f *os.File) error {
+	defer f.Close()
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+func writeLockHolder(f *os.File) error {
+	host, _ := os.Hostname()
+
+	b, err := json.Marshal(lockHolder{PID: os.Getpid(), Host: host, Started: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+
+	_, err = f.WriteAt(b, 0)
+	return err
+}
+
+// staleLock reports whether the lock file at path names a holder on this host
+// that is no longer running. A holder that can't be identified is assumed
+// alive.
+func staleLock(path string) bool {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var holder lockHolder
+	if err := json.Unmarshal(b, &holder); err != nil || holder.PID <= 0 {
+		return false
+	}
+
+	if host, _ := os.Hostname(); holder.Host != host {
+		return false
+	}
+
+	return errors.Is(syscall.Kill(holder.PID, 0), syscall.ESRCH)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"time"
+	"unsafe"
+)
+
+// LockStat describes the contention seen on one collection lock.
+type LockStat struct {
+	// Contended counts the acquisitions that had to wait, and the TryWrite
+	// calls that gave up because the lock was held.
+	Contended int64
+	// Wait is the total time spent waiting.
+	Wait time.Duration
+}
+
+// LockStats returns contention counters per collection. It is only populated
+// when Options.LockStats is set; uncontended collections are absent.
+func (d *Driver) LockStats() map[string]LockStat {
+	d.cacheMutex.Lock()
+	defer d.cacheMutex.Unlock()
+
+	stats := make(map[string]LockStat, len(d.stats))
+	for collection, s := range d.stats {
+		stats[collection] = s
+	}
+
+	return stats
+}
+
+func (d *Driver) recordContention(collection string, wait time.Duration) {
+	collection = d.normalize(collection)
+
+	d.cacheMutex.Lock()
+	defer d.cacheMutex.Unlock()
+
+	s := d.stats[collection]
+	s.Contended++
+	s.Wait += wait
+	d.stats[collection] = s
+}
+
+// InternalStats describes the driver's in-memory bookkeeping, which grows
+// with the number of distinct collections used.
+type InternalStats struct {
+	// MutexCount is the number of collection locks held in memory.
+	MutexCount int
+	// CacheEntries is the number of cached collection metadata entries and
+	// record counts.
+	CacheEntries int
+	// CacheBytes approximates the memory those entries and the lock
+	// statistics hold: keys, values and field type names, not map overhead.
+	CacheBytes int
+	// WatchersActive is the number of active change watchers. The driver has
+	// no watch API, so it is always zero.
+	WatchersActive int
+}
+
+// InternalStats reports the size of the driver's in-memory bookkeeping, to
+// spot unbounded growth in long-running services.
+func (d *Driver) InternalStats() InternalStats {
+	var stats InternalStats
+
+	d.mutex.Lock()
+	stats.MutexCount = len(d.mutexes)
+	d.mutex.Unlock()
+
+	d.cacheMutex.Lock()
+	stats.CacheEntries = len(d.metas) + len(d.counts)
+
+	for collection, meta := range d.metas {
+		stats.CacheBytes += len(collection) + int(unsafe.Sizeof(meta))
+		for field, kind := range meta.FieldTypes {
+			stats.CacheBytes += len(field) + len(kind)
+		}
+	}
+	for collection := range d.counts {
+		stats.CacheBytes += len(collection) + int(unsafe.Sizeof(int(0)))
+	}
+	for collection := range d.stats {
+		stats.CacheBytes += len(collection) + int(unsafe.Sizeof(LockStat{}))
+	}
+	d.cacheMutex.Unlock()
+
+	return stats
+}
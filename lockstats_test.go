@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockStats(t *testing.T) {
+	d := newDB(t, &Options{LockStats: true})
+	unlock, _ := d.lock("c")
+	done := make(chan struct{})
+	go func() { d.Write("c", "a", 1); close(done) }()
+	time.Sleep(20 * time.Millisecond)
+	unlock()
+	<-done
+	s := d.LockStats()["c"]
+	if s.Contended != 1 || s.Wait < 10*time.Millisecond {
+		t.Fatal(s)
+	}
+}
+
+func TestInternalStats(t *testing.T) {
+	d := newDB(t, nil)
+	before := d.InternalStats()
+	for _, c := range []string{"a", "b", "c"} {
+		d.Write(c, "x", 1)
+	}
+	d.Write("a", "y", 1)
+	if s := d.InternalStats(); s.MutexCount != before.MutexCount+3 || s.CacheEntries < 3 {
+		t.Fatal(before, s)
+	}
+}
+
+func TestInternalStatsCacheBytes(t *testing.T) {
+	d := newDB(t, nil)
+	before := d.InternalStats().CacheBytes
+	d.SetFieldTypes("c", map[string]string{"Age": "number"})
+	d.Count("c")
+	after := d.InternalStats()
+	if after.CacheBytes <= before || after.WatchersActive != 0 {
+		t.Fatalf("%+v", after)
+	}
+}
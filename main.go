@@ -1,12 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jcelliott/lumber"
 )
@@ -24,17 +32,175 @@ type (
 	}
 
 	Driver struct {
-		mutex   sync.Mutex
-		mutexes map[string]*sync.Mutex
-		dir     string
-		log     Logger
+		mutex    sync.Mutex
+		mutexes  map[string]*sync.RWMutex
+		dir      string
+		log      Logger
+		opts     Options
+		lockFile *os.File
+		created  bool
+
+		// cacheMutex guards the caches below. It is taken while collection
+		// locks are held, so it must never be held while acquiring one.
+		cacheMutex sync.Mutex
+		metas      map[string]collectionMeta
+		counts     map[string]int
+		stats      map[string]LockStat
 	}
 
 	Options struct {
 		Logger
+
+		// LogLevel sets the verbosity of the default console logger: one of
+		// TRACE, DEBUG, INFO, WARN, ERROR or FATAL. Defaults to INFO and is
+		// ignored when a Logger is supplied.
+		LogLevel string
+
+		// MmapThreshold enables memory-mapped reads for records of at least
+		// this many bytes. Zero disables it. Only honored on Unix platforms.
+		MmapThreshold int64
+
+		// Exclusive takes an advisory lock on the database directory so a
+		// second process opening it fails with ErrLocked until Close. The lock
+		// is flock(2) based and only available on Unix platforms.
+		Exclusive bool
+
+		// BreakStaleLock lets New reclaim the Exclusive lock when the process
+		// recorded as holding it, on this host, no longer exists, for lock
+		// files left behind by a crash on filesystems that keep them held.
+		BreakStaleLock bool
+
+		// ContinueOnMigrateError makes Migrate attempt every record instead of
+		// stopping at the first one whose transform fails.
+		ContinueOnMigrateError bool
+
+		// DryRun makes destructive bulk operations (Migrate and friends)
+		// report what they would change without touching the filesystem:
+		// each logs the records it would affect, and those returning counts
+		// or names return what they would have changed.
+		DryRun bool
+
+		// OmitTrailingNewline stops Write from appending a newline after the
+		// marshaled record, for byte-exact output.
+		OmitTrailingNewline bool
+
+		// RetryPolicy retries transient errors on the write path.
+		RetryPolicy RetryPolicy
+
+		// EncodeKeys escapes resource names before using them as file names,
+		// so any string, including ones with slashes, can be used as a key.
+		// Listings decode the names back. Don't toggle it on an existing
+		// database.
+		EncodeKeys bool
+
+		// NormalizeName, when set, is applied to every collection name before
+		// it is used, e.g. strings.ToLower so "Users" and "users" are the same
+		// collection. It must be deterministic and idempotent.
+		NormalizeName func(string) string
+
+		// NormalizeResources applies NormalizeName to resource names too.
+		NormalizeResources bool
+
+		// UseNumber decodes numbers into interface{} values as json.Number
+		// instead of float64, so large integers survive generic reads and
+		// Update.
+		UseNumber bool
+
+		// DisableHTMLEscape stores <, > and & literally instead of as \u003c
+		// style escapes, which reads better for URLs and HTML fragments.
+		DisableHTMLEscape bool
+
+		// LockStats records how often each collection lock was contended and
+		// how long callers waited for it; see Driver.LockStats.
+		LockStats bool
+
+		// ValidateResource enforces application naming rules on resource
+		// names. It runs after, never instead of, the built-in checks, and its
+		// error is returned wrapped in ErrInvalidResource, so both remain
+		// visible to errors.Is.
+		ValidateResource func(string) error
+
+		// LockTimeout bounds how long an operation waits for its collection
+		// lock before giving up with ErrLockTimeout. Zero waits forever.
+		LockTimeout time.Duration
+
+		// Dedup stores each distinct record body once per collection, in its
+		// _blobs directory, with record files pointing at it by content hash.
+		// Reads follow the pointers; GCBlobs reclaims unreferenced bodies.
+		// Don't turn it off on a database written with it.
+		Dedup bool
+
+		// Tombstones leaves a timestamped marker for every removed record so
+		// deletions can be replicated; see Driver.Tombstones and
+		// PurgeTombstones.
+		Tombstones bool
+
+		// Sharding spreads each collection's record files over this many
+		// levels of subdirectories named after a hash of the record name,
+		// e.g. users/_3f/bob.json, keeping directories small at scale. At most
+		// 4; zero keeps records directly in the collection directory. Use
+		// Reshard after changing it on existing data.
+		Sharding int
+
+		// PublishStrategy selects how a written record is moved into place;
+		// the default renames it.
+		PublishStrategy PublishStrategy
+
+		// Envelope stores each record as {"meta": {...}, "data": {...}}, with
+		// its creation and update times and a version counted up on every
+		// write. Reads unwrap it transparently; see Driver.ReadEnvelope.
+		Envelope bool
+
+		// CompressThreshold gzips records larger than this many bytes into
+		// .json.gz files, leaving smaller ones as plain .json. Reads decode
+		// either transparently. Zero never compresses. Under Dedup it is the
+		// blobs that are compressed.
+		CompressThreshold int
+
+		// ResolveSymlinks resolves symlinks in the database directory once,
+		// in New, and uses the real path from then on.
+		ResolveSymlinks bool
+
+		// Root confines the database: New fails with ErrOutsideRoot when the
+		// directory, with symlinks resolved, isn't inside Root. That keeps a
+		// symlinked directory from redirecting writes elsewhere.
+		Root string
+
+		// OpTimeout bounds how long Write, Read and Delete may take, for
+		// storage that can hang, such as a stuck NFS mount. Past it they
+		// return ErrTimeout, but the filesystem call they were blocked in may
+		// still complete in the background. Zero waits forever.
+		OpTimeout time.Duration
+
+		// AutoCreateOnRead makes reads treat a collection that doesn't exist
+		// yet as an empty one: ReadAll and friends return no records and Count
+		// zero instead of failing. Nothing is created on disk by a read.
+		AutoCreateOnRead bool
+
+		// DefaultCollection is the collection the key-value methods Set,
+		// GetInto and Remove work on. Defaults to _kv, which, like every
+		// name starting with an underscore, Collections leaves out.
+		DefaultCollection string
 	}
 )
 
+var (
+	ErrLocked         = errors.New("Database Locked - another process holds the lock!")
+	ErrRecordNotFound = errors.New("Unable to find record")
+	ErrAlreadyExists  = errors.New("Record already exists")
+	ErrConflict       = errors.New("Name conflict")
+	ErrImmutable      = errors.New("Record is immutable")
+	ErrCollectionFull = errors.New("Collection is full")
+	ErrLockTimeout    = errors.New("Timed out waiting for collection lock")
+	ErrFieldType      = errors.New("Field type mismatch")
+	ErrNoSuchField    = errors.New("JSON pointer does not resolve")
+	ErrOutsideRoot    = errors.New("Database directory is outside its root")
+	ErrTimeout        = errors.New("Operation timed out")
+
+	ErrInvalidCollection = errors.New("Invalid Collection")
+	ErrInvalidResource   = errors.New("Invalid Resource")
+)
+
 func New(dir string, options *Options) (*Driver, error) {
 	dir = filepath.Clean(dir)
 
@@ -44,157 +210,1566 @@ func New(dir string, options *Options) (*Driver, error) {
 	}
 
 	if opts.Logger == nil {
-		opts.Logger = lumber.NewConsoleLogger((lumber.INFO))
+		level := lumber.INFO
+		if opts.LogLevel != "" {
+			level = lumber.LvlInt(opts.LogLevel)
+			if strings.TrimSpace(lumber.LvlStr(level)) != strings.ToUpper(opts.LogLevel) {
+				return nil, fmt.Errorf("Unknown log level '%s'!", opts.LogLevel)
+			}
+		}
+		opts.Logger = lumber.NewConsoleLogger(level)
+	}
+
+	if opts.Sharding < 0 || opts.Sharding > maxSharding {
+		return nil, fmt.Errorf("Invalid Sharding %d - must be between 0 and %d!", opts.Sharding, maxSharding)
+	}
+
+	if opts.ResolveSymlinks || opts.Root != "" {
+		real, err := confineDir(dir, opts.Root)
+		if err != nil {
+			return nil, err
+		}
+		if opts.ResolveSymlinks {
+			dir = real
+		}
 	}
 
 	driver := Driver{
 		dir:     dir,
-		mutexes: make(map[string]*sync.Mutex),
+		mutexes: make(map[string]*sync.RWMutex),
+		metas:   make(map[string]collectionMeta),
+		counts:  make(map[string]int),
+		stats:   make(map[string]LockStat),
 		log:     opts.Logger,
+		opts:    *opts,
 	}
 
+	created := false
+
 	if _, err := os.Stat(dir); err == nil {
 		opts.Logger.Debug("Using '%s' ('database already exists') \n", dir)
-		return &driver, nil
+	} else {
+		opts.Logger.Debug("Creating Database at '%s'...  \n", dir)
+		err := os.Mkdir(dir, 0755) // 0755 is the read access permission
+
+		// Another New may have created the directory between our Stat and
+		// Mkdir; that's as good as finding it there in the first place.
+		switch {
+		case os.IsExist(err):
+			opts.Logger.Debug("Using '%s' ('database created concurrently') \n", dir)
+		case err != nil:
+			return &driver, err
+		default:
+			created = true
+		}
+	}
+
+	// Once the directory is created New either finishes initializing it or
+	// removes it again, so a retry starts clean. A directory that was already
+	// there is never removed.
+	abort := func(err error) (*Driver, error) {
+		if created {
+			if rerr := os.RemoveAll(dir); rerr != nil {
+				opts.Logger.Warn("Unable to remove half-initialized '%s': %v \n", dir, rerr)
+			}
+		}
+		return nil, err
+	}
+
+	if created {
+		if err := writeMeta(dir); err != nil {
+			return abort(err)
+		}
+	} else {
+		driver.checkMeta()
+	}
+	driver.created = created
+
+	if opts.Exclusive {
+		f, err := lockDir(dir, opts.BreakStaleLock)
+		if err != nil {
+			return abort(err)
+		}
+		driver.lockFile = f
+	}
+
+	return &driver, nil
+}
+
+// Sub returns a driver rooted at prefix inside this database, for handing a
+// component its own corner of the tree. The sub-driver shares the options but
+// none of the locking state, so don't use the parent and the sub-driver on the
+// same collections concurrently.
+func (d *Driver) Sub(prefix string) (*Driver, error) {
+	if prefix == "" {
+		return nil, errors.New("Missing Prefix - unable to scope driver!")
+	}
+
+	if err := d.validateCollection(prefix); err != nil {
+		return nil, err
+	}
+
+	dir := d.collectionDir(prefix)
+
+	_, err := os.Stat(dir)
+	created := os.IsNotExist(err)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Driver{
+		dir:     dir,
+		mutexes: make(map[string]*sync.RWMutex),
+		metas:   make(map[string]collectionMeta),
+		counts:  make(map[string]int),
+		stats:   make(map[string]LockStat),
+		log:     d.log,
+		opts:    d.opts,
+		created: created,
+	}, nil
+}
+
+// WasCreated reports whether New created the database directory, as opposed
+// to opening an existing one. Handy for first-run seeding.
+func (d *Driver) WasCreated() bool {
+	return d.created
+}
+
+// Close releases the exclusive lock, if one was taken. The driver must not be
+// used afterwards.
+func (d *Driver) Close() error {
+	if d.lockFile == nil {
+		return nil
 	}
 
-	opts.Logger.Debug("Creating Database at '%s'...  \n", dir)
-	return &driver, os.Mkdir(dir, 0755) // 0755 is the read access permission
+	err := unlockDir(d.lockFile)
+	d.lockFile = nil
+	return err
 }
 
 func (d *Driver) Write(collection string, resource string, v interface{}) error {
-	err := validateCollectionResource(collection, resource)
+	_, err := d.WriteN(collection, resource, v)
+	return err
+}
+
+// WriteN is Write that also reports how many bytes were persisted: the size
+// of the record file as stored, which differs from the marshaled size under
+// Options.Envelope and CompressThreshold. Under Options.Dedup it is the size
+// of the pointer; the shared blob is not counted.
+func (d *Driver) WriteN(collection string, resource string, v interface{}) (int, error) {
+	err := d.validateCollectionResource(collection, resource)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	b, err := d.marshal(v)
+	if err != nil {
+		return 0, err
+	}
 
-	dir := filepath.Join(d.dir, collection)
-	fnlPath := filepath.Join(dir, resource+".json")
-	tmpPath := fnlPath + ".tmp"
+	var n int
+	err = d.withTimeout(func() error {
+		unlock, err := d.lock(collection)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
-	if err := os.MkdirAll(dir, 0755); err != nil {
+		n, err = d.writeFileN(collection, resource, b)
 		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	b, err := json.MarshalIndent(v, "", "\t")
+	return n, nil
+}
+
+// Create writes a record only if it doesn't exist yet, failing with
+// ErrAlreadyExists otherwise.
+func (d *Driver) Create(collection string, resource string, v interface{}) error {
+	err := d.validateCollectionResource(collection, resource)
 	if err != nil {
 		return err
 	}
 
-	b = append(b, byte('\n'))
+	b, err := d.marshal(v)
+	if err != nil {
+		return err
+	}
 
-	if err := os.WriteFile(tmpPath, b, 0644); err != nil {
+	unlock, err := d.lock(collection)
+	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	return os.Rename(tmpPath, fnlPath)
+	return d.createFile(collection, resource, b)
 }
 
-func (d *Driver) Read(collection string, resource string, v interface{}) error {
-	err := validateCollectionResource(collection, resource)
+// createFile publishes b with a hard link rather than a rename: linking fails
+// when the target exists, even if another process just created it, and unlike
+// O_EXCL it never exposes a half-written file. Filesystems without hard links
+// fall back to O_EXCL. Callers must hold the collection lock.
+func (d *Driver) createFile(collection string, resource string, b []byte) error {
+	fnlPath := d.recordPath(collection, resource) + ".json"
+
+	if err := d.checkFieldTypes(collection, resource, bytes.NewReader(b)); err != nil {
+		return err
+	}
+
+	added, evict, err := d.admission(collection, resource)
 	if err != nil {
 		return err
 	}
 
-	record := filepath.Join(d.dir, collection, resource)
+	if err := d.prepareCollection(collection, resource); err != nil {
+		return err
+	}
+
+	// The link below only fails for the variant being written, so look for
+	// the record in every variant first.
+	if _, _, err := resolveRecord(fnlPath); err == nil {
+		return fmt.Errorf("%w '%s'", ErrAlreadyExists, filepath.Join(collection, resource))
+	}
 
-	if _, err := stat(record); err != nil {
+	linkPath, b, err := d.storedRecord(collection, fnlPath, b)
+	if err != nil {
 		return err
 	}
 
-	b, err := os.ReadFile(record + ".json")
+	tmpPath, err := d.writeTemp(linkPath, b)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpPath)
 
-	return json.Unmarshal(b, &v)
-}
+	if err := d.makeRoom(collection, evict); err != nil {
+		return err
+	}
 
-func validateCollectionResource(collection string, resource string) error {
-	if collection == "" {
-		return errors.New("Missing Collection - no place to save the records!")
+	err = os.Link(tmpPath, linkPath)
+	if linkUnsupported(err) {
+		d.log.Debug("Hard links unsupported for '%s', creating exclusively instead: %v \n", linkPath, err)
+		err = createExclusive(linkPath, b)
+	}
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%w '%s'", ErrAlreadyExists, filepath.Join(collection, resource))
+		}
+		return err
 	}
 
-	if resource == "" {
-		return errors.New("Missing Resource - unable to save record (No Name)!")
+	if err := d.clearTombstone(fnlPath); err != nil {
+		return err
+	}
+
+	if added {
+		d.adjustCount(collection, 1)
 	}
 
 	return nil
 }
 
-func (d *Driver) ReadAll(collection string) ([]string, error) {
-	if collection == "" {
-		return nil, errors.New("Missing Collection - unable to read!")
+// WriteRaw stores already-serialized JSON as-is, without re-marshaling it.
+func (d *Driver) WriteRaw(collection string, resource string, raw json.RawMessage) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
 	}
 
-	dir := filepath.Join(d.dir, collection)
+	if !json.Valid(raw) {
+		return errors.New("Invalid JSON - refusing to save raw record!")
+	}
 
-	if _, err := stat(dir); err != nil {
-		return nil, err
+	return d.write(collection, resource, raw)
+}
+
+// unmarshal decodes a stored record into v, keeping numbers as json.Number
+// when UseNumber is set.
+func (d *Driver) unmarshal(b []byte, v interface{}) error {
+	if !d.opts.UseNumber {
+		return json.Unmarshal(b, v)
 	}
 
-	files, _ := os.ReadDir(dir)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
 
-	var records []string
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
 
-	for _, f := range files {
-		b, err := os.ReadFile(filepath.Join(dir, f.Name()))
-		if err != nil {
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("Invalid JSON - unexpected data after the record!")
+	}
+
+	return nil
+}
+
+// marshal encodes v the way records are stored on disk.
+func (d *Driver) marshal(v interface{}) ([]byte, error) {
+	if d.opts.DisableHTMLEscape {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "\t")
+		enc.SetEscapeHTML(false)
+
+		// Encode always terminates the value with a newline.
+		if err := enc.Encode(v); err != nil {
 			return nil, err
 		}
 
-		records = append(records, string(b))
+		b := buf.Bytes()
+		if d.opts.OmitTrailingNewline {
+			b = b[:len(b)-1]
+		}
+
+		return b, nil
 	}
 
-	return records, nil
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	if !d.opts.OmitTrailingNewline {
+		b = append(b, byte('\n'))
+	}
+
+	return b, nil
 }
 
-func (d *Driver) Delete(collection string, resource string) error {
-	err := validateCollectionResource(collection, resource)
+// WriteWith stores v encoded by a caller-supplied marshal func instead of the
+// driver's default encoding, e.g. an encoder with HTML escaping turned off. The
+// output is stored exactly as returned.
+func (d *Driver) WriteWith(collection string, resource string, v interface{}, marshal func(interface{}) ([]byte, error)) error {
+	b, err := marshal(v)
 	if err != nil {
 		return err
 	}
 
-	path := filepath.Join(collection, resource)
-	mutex := d.getOrCreateMutex(collection)
-	mutex.Lock()
-	defer mutex.Unlock()
+	return d.WriteRaw(collection, resource, b)
+}
 
-	dir := filepath.Join(d.dir, path)
-	switch fi, err := stat(dir); {
-	case fi == nil, err != nil:
-		return fmt.Errorf("Unable to find record. Dir %v\n", path)
-	case fi.Mode().IsDir():
-		return os.RemoveAll(dir)
+// TryWrite is Write for best-effort callers: if the collection lock is held it
+// returns false immediately instead of waiting, and nothing is written.
+func (d *Driver) TryWrite(collection string, resource string, v interface{}) (bool, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return false, err
+	}
 
-	case fi.Mode().IsRegular():
-		return os.RemoveAll(dir + ".json")
+	b, err := d.marshal(v)
+	if err != nil {
+		return false, err
+	}
+
+	written := false
+	err = d.withTimeout(func() error {
+		unlock := d.tryLock(collection)
+		if unlock == nil {
+			return nil
+		}
+		defer unlock()
+
+		if err := d.writeFile(collection, resource, b); err != nil {
+			return err
+		}
+
+		written = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return written, nil
+}
+
+// write atomically replaces the record file with b under the collection lock.
+func (d *Driver) write(collection string, resource string, b []byte) error {
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.writeFile(collection, resource, b)
+}
+
+// prepareCollection creates the collection directory ahead of writing
+// resource into it. Because stat resolves both "name" and "name.json", a record
+// and a nested collection sharing a name would make lookups ambiguous, so that
+// combination is refused with ErrConflict in either direction.
+func (d *Driver) prepareCollection(collection string, resource string) error {
+	if fi, err := os.Stat(d.recordPath(collection, resource)); err == nil && fi.IsDir() {
+		return fmt.Errorf("%w: '%s' is a collection, not a record", ErrConflict, filepath.Join(collection, resource))
+	}
+
+	if err := d.makeCollectionDir(collection); err != nil {
+		return err
+	}
+
+	if d.opts.Sharding > 0 {
+		return d.retry(func() error { return os.MkdirAll(filepath.Dir(d.recordPath(collection, resource)), 0755) })
 	}
 
 	return nil
 }
 
-func (d *Driver) getOrCreateMutex(collection string) *sync.Mutex {
-	d.mutex.Lock()
-	defer d.mutex.Unlock()
+// makeCollectionDir creates the directories along collection's path, refusing
+// any segment already taken by a record.
+func (d *Driver) makeCollectionDir(collection string) error {
+	if err := d.checkCollectionPath(collection); err != nil {
+		return err
+	}
 
-	m, ok := d.mutexes[collection]
+	return d.retry(func() error { return os.MkdirAll(d.collectionDir(collection), 0755) })
+}
 
-	if !ok {
-		m = &sync.Mutex{}
-		d.mutexes[collection] = m
+// checkCollectionPath fails with ErrConflict when a record or some other file
+// is in the way of collection's directory or one of its parents.
+func (d *Driver) checkCollectionPath(collection string) error {
+	path := d.dir
+	for _, segment := range strings.Split(d.normalize(collection), "/") {
+		if _, _, err := resolveRecord(filepath.Join(path, segment) + ".json"); err == nil {
+			return fmt.Errorf("%w: '%s' is a record, not a collection", ErrConflict, filepath.Join(path, segment))
+		}
+		path = filepath.Join(path, segment)
+
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return fmt.Errorf("%w: cannot create collection '%s' - a file with that name already exists at '%s'", ErrConflict, collection, path)
+		}
 	}
 
-	return m
+	return nil
 }
 
-func stat(path string) (fi os.FileInfo, err error) {
-	if fi, err = os.Stat(path); os.IsNotExist(err) {
-		fi, err = os.Stat(path + ".json")
+// writeFile does the temp-file-and-rename dance; callers must hold the
+// collection lock.
+func (d *Driver) writeFile(collection string, resource string, b []byte) error {
+	_, err := d.writeFileN(collection, resource, b)
+	return err
+}
+
+// writeFileN is writeFile that also returns the size of the record file as
+// stored.
+func (d *Driver) writeFileN(collection string, resource string, b []byte) (int, error) {
+	fnlPath := d.recordPath(collection, resource) + ".json"
+
+	if err := d.checkFieldTypes(collection, resource, bytes.NewReader(b)); err != nil {
+		return 0, err
+	}
+
+	added, evict, err := d.admission(collection, resource)
+	if err != nil {
+		return 0, err
+	}
+
+	// The collection lock doesn't keep out another process, or a
+	// DropCollection of a parent collection, so the directory can vanish
+	// between creating it and renaming into it. Recreate it and try once more.
+	n, err := d.publish(fnlPath, collection, resource, b, evict)
+	if os.IsNotExist(err) {
+		d.log.Debug("Collection '%s' vanished while writing '%s', retrying \n", collection, resource)
+		n, err = d.publish(fnlPath, collection, resource, b, evict)
+	}
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("Unable to write '%s' - its collection was removed during the write: %w", filepath.Join(collection, resource), err)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.clearTombstone(fnlPath); err != nil {
+		return 0, err
+	}
+
+	if added {
+		d.adjustCount(collection, 1)
+	}
+
+	return n, nil
+}
+
+// publish creates the collection directory and moves the payload b into
+// place at fnlPath through a temp file, evicting evict records once it is
+// staged. It returns the size of the file stored.
+func (d *Driver) publish(fnlPath string, collection string, resource string, b []byte, evict int) (int, error) {
+	if err := d.prepareCollection(collection, resource); err != nil {
+		return 0, err
+	}
+
+	fnlPath, b, err := d.storedRecord(collection, fnlPath, b)
+	if err != nil {
+		return 0, err
+	}
+
+	tmpPath, err := d.writeTemp(fnlPath, b)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := d.makeRoom(collection, evict); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	if err := d.commitFile(tmpPath, fnlPath); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+
+	return len(b), removeVariants(fnlPath)
+}
+
+// Update merges fields into an existing record. The record is decoded into a
+// generic map rather than a typed struct, so fields the caller doesn't know
+// about survive the read-modify-write.
+func (d *Driver) Update(collection string, resource string, fields map[string]interface{}) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record := d.recordPath(collection, resource)
+
+	if _, err := stat(record + ".json"); err != nil {
+		return recordError(collection, resource, err)
+	}
+
+	b, err := d.readRecord(record + ".json")
+	if err != nil {
+		return recordError(collection, resource, err)
+	}
+
+	b, err = d.patchRecord(b, fields)
+	if err != nil {
+		return err
+	}
+
+	return d.writeFile(collection, resource, b)
+}
+
+// UpsertWith writes v like Write, except that when the record already exists
+// resolve is called under the collection lock with the stored and the incoming
+// record, and what it returns is written instead. That allows merges such as
+// keeping the higher version or summing counters.
+func (d *Driver) UpsertWith(collection string, resource string, v interface{}, resolve func(existing json.RawMessage, incoming json.RawMessage) (json.RawMessage, error)) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	b, err := d.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	existing, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+	switch {
+	case os.IsNotExist(err):
+		return d.writeFile(collection, resource, b)
+	case err != nil:
+		return err
+	}
+
+	resolved, err := resolve(existing, b)
+	if err != nil {
+		return err
+	}
+
+	if !json.Valid(resolved) {
+		return fmt.Errorf("Invalid JSON - resolving '%s' did not produce a valid record!", filepath.Join(collection, resource))
+	}
+
+	if b, err = d.marshal(resolved); err != nil {
+		return err
+	}
+
+	return d.writeFile(collection, resource, b)
+}
+
+// patchRecord shallow-merges fields into the record b, going through a generic
+// map so unknown fields are kept.
+func (d *Driver) patchRecord(b []byte, fields map[string]interface{}) ([]byte, error) {
+	current := map[string]interface{}{}
+	if err := d.unmarshal(b, &current); err != nil {
+		return nil, err
+	}
+
+	for k, v := range fields {
+		current[k] = v
+	}
+
+	return d.marshal(current)
+}
+
+func (d *Driver) Read(collection string, resource string, v interface{}) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	if err := validateTarget(v); err != nil {
+		return err
+	}
+
+	// Under OpTimeout v is only filled in once the read has finished in time,
+	// never by a read left running in the background.
+	if d.opts.OpTimeout > 0 {
+		var b json.RawMessage
+		err := d.withTimeout(func() (err error) {
+			b, err = d.ReadRaw(collection, resource)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return d.unmarshal(b, v)
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record := d.recordPath(collection, resource)
+
+	if _, err := stat(record + ".json"); err != nil {
+		return recordError(collection, resource, err)
+	}
+
+	b, release, err := d.readFile(record + ".json")
+	if err != nil {
+		return recordError(collection, resource, err)
+	}
+	defer release()
+
+	return d.unmarshal(b, v)
+}
+
+// ReadStream decodes a record straight from its file handle instead of
+// loading the whole file first, keeping peak memory low for big records.
+func (d *Driver) ReadStream(collection string, resource string, v interface{}) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	if err := validateTarget(v); err != nil {
+		return err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record := d.recordPath(collection, resource)
+
+	if _, err := stat(record + ".json"); err != nil {
+		return recordError(collection, resource, err)
+	}
+
+	f, err := d.openRecord(record + ".json")
+	if err != nil {
+		return recordError(collection, resource, err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	if d.opts.UseNumber {
+		dec.UseNumber()
+	}
+
+	return dec.Decode(v)
+}
+
+// ReadRaw returns the stored bytes of a record verbatim.
+func (d *Driver) ReadRaw(collection string, resource string) (json.RawMessage, error) {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	record := d.recordPath(collection, resource)
+
+	if _, err := stat(record + ".json"); err != nil {
+		return nil, recordError(collection, resource, err)
+	}
+
+	b, err := d.readRecord(record + ".json")
+	if err != nil {
+		return nil, recordError(collection, resource, err)
+	}
+
+	return b, nil
+}
+
+// ReadMap reads a record into a generic map, for ad-hoc access without a
+// struct. Numbers follow Options.UseNumber.
+func (d *Driver) ReadMap(collection string, resource string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	if err := d.Read(collection, resource, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadAllMaps is the bulk counterpart of ReadMap.
+func (d *Driver) ReadAllMaps(collection string) ([]map[string]interface{}, error) {
+	records, err := d.ReadAllRaw(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make([]map[string]interface{}, 0, len(records))
+	for _, b := range records {
+		var m map[string]interface{}
+		if err := d.unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		maps = append(maps, m)
+	}
+
+	return maps, nil
+}
+
+func (d *Driver) validateCollectionResource(collection string, resource string) error {
+	if collection == "" {
+		return errors.New("Missing Collection - no place to save the records!")
+	}
+
+	if resource == "" {
+		return errors.New("Missing Resource - unable to save record (No Name)!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	resource = d.normalizeResource(resource)
+	if resource == "" {
+		return errors.New("Missing Resource - unable to save record (No Name)!")
+	}
+
+	if !d.opts.EncodeKeys && (resource == "." || resource == ".." || strings.ContainsAny(resource, `/\`)) {
+		return fmt.Errorf("%w '%s' - names may not contain path separators!", ErrInvalidResource, resource)
+	}
+
+	// Names starting with an underscore are the driver's own, like the
+	// collection's _meta.json, and are never listed as records.
+	if !d.opts.EncodeKeys && strings.HasPrefix(resource, "_") {
+		return fmt.Errorf("%w '%s' - names starting with '_' are reserved!", ErrInvalidResource, resource)
+	}
+
+	if d.opts.ValidateResource != nil {
+		if err := d.opts.ValidateResource(resource); err != nil {
+			return fmt.Errorf("%w '%s' - %w", ErrInvalidResource, resource, err)
+		}
+	}
+
+	return nil
+}
+
+// validateCollection allows nested, slash-delimited collection paths such as
+// "users/2024/active" but rejects anything that could escape the database.
+// The name is checked after normalization, since that is what reaches disk.
+func (d *Driver) validateCollection(collection string) error {
+	for _, segment := range strings.Split(d.normalize(collection), "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.ContainsRune(segment, '\\') {
+			return fmt.Errorf("%w '%s' - path segments must be plain names!", ErrInvalidCollection, collection)
+		}
+	}
+
+	return nil
+}
+
+func validateTarget(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("Read target must be a non-nil pointer")
+	}
+
+	return nil
+}
+
+func (d *Driver) ReadAll(collection string) ([]string, error) {
+	return d.ReadAllLimit(collection, 0)
+}
+
+// ReadAllLimit is ReadAll reading no more than max records, the first ones by
+// resource name, for previews and samples of big collections. A max of zero
+// or less reads them all, like ReadAll.
+func (d *Driver) ReadAllLimit(collection string, max int) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	switch _, err := stat(dir); {
+	case d.missingAsEmpty(err):
+		return []string{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	files, _ := d.recordFiles(dir)
+
+	if max > 0 && len(files) > max {
+		sort.Slice(files, func(i, j int) bool {
+			return trimRecordExt(files[i].Name()) < trimRecordExt(files[j].Name())
+		})
+		files = files[:max]
+	}
+
+	records := make([]string, 0, len(files))
+
+	for _, f := range files {
+		var (
+			b       []byte
+			release func()
+		)
+		err := retryOpen(func() (err error) {
+			b, release, err = d.readFile(f.path)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, string(b))
+		release()
+	}
+
+	return records, nil
+}
+
+// ReadAllRaw is ReadAll without the string conversion, for callers that want
+// to decode records selectively.
+func (d *Driver) ReadAllRaw(collection string) ([]json.RawMessage, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	switch _, err := stat(dir); {
+	case d.missingAsEmpty(err):
+		return []json.RawMessage{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	files, _ := d.recordFiles(dir)
+
+	records := make([]json.RawMessage, 0, len(files))
+
+	for _, f := range files {
+		var b []byte
+		err := retryOpen(func() (err error) {
+			b, err = d.readRecord(f.path)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, b)
+	}
+
+	return records, nil
+}
+
+// RecordResult is one record returned by ReadAllResults. Err is set when the
+// record couldn't be read or isn't valid JSON; Raw holds whatever was read.
+type RecordResult struct {
+	Resource string
+	Raw      json.RawMessage
+	Err      error
+}
+
+// ReadAllResults is ReadAllRaw for partially corrupt collections: a record
+// that can't be read or parsed is reported in its result instead of failing
+// the whole call. The error is reserved for problems with the collection
+// itself.
+func (d *Driver) ReadAllResults(collection string) ([]RecordResult, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	files, err := d.recordFiles(d.collectionDir(collection))
+	switch {
+	case d.missingAsEmpty(err):
+		return []RecordResult{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	results := make([]RecordResult, 0, len(files))
+
+	for _, f := range files {
+		result := RecordResult{Resource: d.decodeKey(trimRecordExt(f.Name()))}
+
+		result.Err = retryOpen(func() (err error) {
+			result.Raw, err = d.readRecord(f.path)
+			return err
+		})
+		if result.Err == nil && !json.Valid(result.Raw) {
+			result.Err = fmt.Errorf("Invalid JSON in record '%s'", filepath.Join(collection, result.Resource))
+		}
+
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// List returns the names of the records in collection.
+func (d *Driver) List(collection string) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to list!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	dir := d.collectionDir(collection)
+
+	switch _, err := stat(dir); {
+	case d.missingAsEmpty(err):
+		return []string{}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	return d.listResources(dir)
+}
+
+// ReadAllWithKeys returns every record in collection keyed by resource name.
+func (d *Driver) ReadAllWithKeys(collection string) (map[string]json.RawMessage, error) {
+	resources, err := d.List(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]json.RawMessage, len(resources))
+
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if err != nil {
+			return nil, err
+		}
+
+		records[resource] = b
+	}
+
+	return records, nil
+}
+
+// PrepareCollection creates collection's lock and directory ahead of time, so
+// the first write into it doesn't pay for either on a latency-sensitive path.
+func (d *Driver) PrepareCollection(collection string) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to prepare!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return d.makeCollectionDir(collection)
+}
+
+// CollectionExists reports whether collection has a directory on disk. A
+// missing collection is not an error.
+func (d *Driver) CollectionExists(collection string) (bool, error) {
+	if collection == "" {
+		return false, errors.New("Missing Collection - unable to check!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return false, err
+	}
+
+	fi, err := os.Stat(d.collectionDir(collection))
+	switch {
+	case os.IsNotExist(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+
+	return fi.IsDir(), nil
+}
+
+// Collections lists the top-level collections. Internal directories, whose
+// names start with an underscore, are left out.
+func (d *Driver) Collections() ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []string
+
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), "_") {
+			continue
+		}
+
+		collections = append(collections, e.Name())
+	}
+
+	return collections, nil
+}
+
+// CollectionsRecursive walks the whole database and returns the path of every
+// collection that directly holds records, e.g. "users/2024/active".
+// Directories that only group other collections are not included.
+func (d *Driver) CollectionsRecursive() ([]string, error) {
+	var collections []string
+
+	err := filepath.WalkDir(d.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !e.IsDir() {
+			return nil
+		}
+
+		if path != d.dir && strings.HasPrefix(e.Name(), "_") {
+			return filepath.SkipDir
+		}
+
+		if path == d.dir {
+			return nil
+		}
+
+		resources, err := d.listResources(path)
+		if err != nil {
+			return err
+		}
+
+		if len(resources) > 0 {
+			rel, err := filepath.Rel(d.dir, path)
+			if err != nil {
+				return err
+			}
+			collections = append(collections, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	return collections, err
+}
+
+// Count returns the number of records stored directly in collection; records
+// in nested collections below it are not included.
+func (d *Driver) Count(collection string) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to count!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	dir := d.collectionDir(collection)
+
+	switch _, err := stat(dir); {
+	case d.missingAsEmpty(err):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+
+	resources, err := d.listResources(dir)
+	return len(resources), err
+}
+
+func (d *Driver) Delete(collection string, resource string) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	return d.withTimeout(func() error { return d.deleteRecord(collection, resource) })
+}
+
+func (d *Driver) deleteRecord(collection string, resource string) error {
+	path := filepath.Join(collection, resource)
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.checkRemove(collection); err != nil {
+		return err
+	}
+
+	dir := d.recordPath(collection, resource)
+	record, _, _ := resolveRecord(dir + ".json")
+	switch fi, err := os.Stat(record); {
+	case os.IsNotExist(err):
+		if fi, err := os.Stat(dir); err == nil && fi.IsDir() {
+			return fmt.Errorf("Refusing to delete '%s' - it is a directory, use DropCollection to remove a collection!", path)
+		}
+		// An attachment stored without a record is still deleted.
+		if removed, err := removeBinary(record); removed || err != nil {
+			return err
+		}
+		return fmt.Errorf("%w '%s'", ErrRecordNotFound, path)
+	case err != nil:
+		return fmt.Errorf("Unable to access record '%s': %w", path, err)
+	case fi.Mode().IsRegular():
+		if err := os.Remove(record); err != nil {
+			return err
+		}
+		d.adjustCount(collection, -1)
+		if _, err := removeBinary(record); err != nil {
+			return err
+		}
+		return d.tombstone(record)
+	default:
+		return fmt.Errorf("Unable to delete record '%s': unsupported file mode %v", path, fi.Mode().Type())
+	}
+}
+
+// Touch bumps a record's modification time without rewriting it, for lease
+// and heartbeat style freshness tracking. Under Options.Envelope the record
+// is rewritten instead, moving its Updated time but not its version.
+func (d *Driver) Touch(collection string, resource string) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	record, _, _ := resolveRecord(d.recordPath(collection, resource) + ".json")
+
+	now := time.Now()
+	if d.opts.Envelope {
+		err = d.touchEnvelope(record, now)
+	} else {
+		err = os.Chtimes(record, now, now)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w '%s'", ErrRecordNotFound, filepath.Join(collection, resource))
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DropCollection removes a collection and every record in it.
+func (d *Driver) DropCollection(collection string) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to drop!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("Unable to drop '%s' - not a collection!", collection)
+	}
+
+	if err := d.checkRemove(collection); err != nil {
+		return err
+	}
+
+	if d.opts.DryRun {
+		resources, err := d.treeResources(dir)
+		if err != nil {
+			return err
+		}
+		d.logDryRun("drop collection", collection, resources)
+		return nil
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+
+	d.cacheMutex.Lock()
+	delete(d.metas, d.normalize(collection))
+	delete(d.counts, d.normalize(collection))
+	d.cacheMutex.Unlock()
+
+	return nil
+}
+
+// treeResources returns the records of the collection at dir and of every
+// collection nested below it, the nested ones prefixed with their path
+// relative to dir, e.g. "2024/report".
+func (d *Driver) treeResources(dir string) ([]string, error) {
+	var resources []string
+
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !e.IsDir() {
+			return nil
+		}
+
+		if path != dir && strings.HasPrefix(e.Name(), "_") {
+			return filepath.SkipDir
+		}
+
+		names, err := d.listResources(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		for _, name := range names {
+			resources = append(resources, filepath.ToSlash(filepath.Join(rel, name)))
+		}
+
+		return nil
+	})
+
+	return resources, err
+}
+
+// logDryRun reports, under Options.DryRun, the records an operation on
+// collection would have affected.
+func (d *Driver) logDryRun(operation string, collection string, resources []string) {
+	d.log.Info("Dry run: would %s '%s', affecting %d: %s \n", operation, collection, len(resources), strings.Join(resources, ", "))
+}
+
+// lockPoll is how often a lock acquisition bounded by Options.LockTimeout
+// retries.
+const lockPoll = time.Millisecond
+
+// lock acquires the collection lock and returns the func that releases it.
+func (d *Driver) lock(collection string) (func(), error) {
+	mutex := d.getOrCreateMutex(collection)
+
+	if err := d.acquire(collection, mutex.TryLock, mutex.Lock); err != nil {
+		return nil, err
+	}
+
+	return mutex.Unlock, nil
+}
+
+// rlock acquires the collection lock shared, so readers run alongside each
+// other but never overlap a write's directory creation or rename.
+func (d *Driver) rlock(collection string) (func(), error) {
+	mutex := d.getOrCreateMutex(collection)
+
+	if err := d.acquire(collection, mutex.TryRLock, mutex.RLock); err != nil {
+		return nil, err
+	}
+
+	return mutex.RUnlock, nil
+}
+
+// tryLock acquires the collection lock only if it is free and returns the
+// func that releases it, or nil when the lock is held. A held lock counts as
+// contention in LockStats, with no wait.
+func (d *Driver) tryLock(collection string) func() {
+	mutex := d.getOrCreateMutex(collection)
+
+	if !mutex.TryLock() {
+		if d.opts.LockStats {
+			d.recordContention(collection, 0)
+		}
+		return nil
+	}
+
+	return mutex.Unlock
+}
+
+func (d *Driver) acquire(collection string, try func() bool, block func()) error {
+	if !d.opts.LockStats && d.opts.LockTimeout <= 0 {
+		block()
+		return nil
+	}
+
+	// Only time acquisitions that actually have to wait, keeping clock reads
+	// off the uncontended path.
+	if try() {
+		return nil
+	}
+
+	start := time.Now()
+
+	if d.opts.LockTimeout <= 0 {
+		block()
+	} else {
+		for !try() {
+			if time.Since(start) >= d.opts.LockTimeout {
+				return fmt.Errorf("%w '%s' after %v", ErrLockTimeout, collection, d.opts.LockTimeout)
+			}
+			time.Sleep(lockPoll)
+		}
+	}
+
+	if d.opts.LockStats {
+		d.recordContention(collection, time.Since(start))
+	}
+
+	return nil
+}
+
+func (d *Driver) getOrCreateMutex(collection string) *sync.RWMutex {
+	collection = d.normalize(collection)
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	m, ok := d.mutexes[collection]
+
+	if !ok {
+		m = &sync.RWMutex{}
+		d.mutexes[collection] = m
+	}
+
+	return m
+}
+
+// readFile returns the contents of path. Files at or above the mmap threshold
+// are mapped rather than copied; the returned release func must be called once
+// the bytes are no longer referenced.
+func (d *Driver) readFile(path string) ([]byte, func(), error) {
+	path, format, err := resolveRecord(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if format.decode != nil || d.opts.Envelope {
+		b, err := d.readRecord(path)
+		return b, func() {}, err
+	}
+
+	if d.opts.Dedup {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hash, ok := parseBlobPointer(b)
+		if !ok {
+			return b, func() {}, nil
+		}
+
+		blob, format, err := resolveRecord(blobPath(d.collectionOf(path), hash))
+		if err != nil {
+			return nil, nil, err
+		}
+		if format.decode != nil {
+			b, err = d.resolveBlob(path, b)
+			return b, func() {}, err
+		}
+		path = blob
+	}
+
+	if d.opts.MmapThreshold > 0 {
+		if fi, err := os.Stat(path); err == nil && fi.Size() >= d.opts.MmapThreshold {
+			return mmapFile(path)
+		}
+	}
+
+	b, err := os.ReadFile(path)
+	return b, func() {}, err
+}
+
+// collectionDir returns the directory backing collection.
+func (d *Driver) collectionDir(collection string) string {
+	return filepath.Join(d.dir, d.normalize(collection))
+}
+
+// recordPath returns the path of a record without its .json extension.
+func (d *Driver) recordPath(collection string, resource string) string {
+	name := d.encodeKey(d.normalizeResource(resource))
+	return filepath.Join(d.collectionDir(collection), d.shardDir(name), name)
+}
+
+func (d *Driver) normalize(collection string) string {
+	if d.opts.NormalizeName == nil {
+		return collection
+	}
+
+	return d.opts.NormalizeName(collection)
+}
+
+func (d *Driver) normalizeResource(resource string) string {
+	if !d.opts.NormalizeResources {
+		return resource
+	}
+
+	return d.normalize(resource)
+}
+
+// encodeKey maps a resource name onto a safe file name when EncodeKeys is on.
+// A leading dot or underscore is escaped too so keys can't collide with
+// "."/".." or the driver's internal files.
+func (d *Driver) encodeKey(resource string) string {
+	if !d.opts.EncodeKeys {
+		return resource
+	}
+
+	name := url.QueryEscape(resource)
+	switch {
+	case strings.HasPrefix(name, "."):
+		name = "%2E" + name[1:]
+	case strings.HasPrefix(name, "_"):
+		name = "%5F" + name[1:]
+	}
+
+	return name
+}
+
+func (d *Driver) decodeKey(name string) string {
+	if !d.opts.EncodeKeys {
+		return name
+	}
+
+	resource, err := url.QueryUnescape(name)
+	if err != nil {
+		return name
+	}
+
+	return resource
+}
+
+// isRecordFile reports whether a collection directory entry holds a record,
+// as opposed to a nested collection, a temp file or internal "_" bookkeeping.
+func isRecordFile(e fs.DirEntry) bool {
+	_, _, ok := splitRecordName(e.Name())
+	return ok && !e.IsDir() && !strings.HasPrefix(e.Name(), "_")
+}
+
+// listResources returns the names of the records stored directly in dir,
+// skipping nested collections and anything that isn't a record file.
+func (d *Driver) listResources(dir string) ([]string, error) {
+	entries, err := d.recordFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]string, 0, len(entries))
+
+	for _, e := range entries {
+		resources = append(resources, d.decodeKey(trimRecordExt(e.Name())))
+	}
+
+	return resources, nil
+}
+
+// missingAsEmpty reports whether err, from looking up a collection for a
+// read, should be taken as an empty collection; see Options.AutoCreateOnRead.
+func (d *Driver) missingAsEmpty(err error) bool {
+	return d.opts.AutoCreateOnRead && os.IsNotExist(err)
+}
+
+// recordError wraps a missing-file error in ErrRecordNotFound so callers can
+// use errors.Is; any other error is returned untouched.
+func recordError(collection string, resource string, err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w '%s': %w", ErrRecordNotFound, filepath.Join(collection, resource), err)
+	}
+
+	return err
+}
+
+func stat(path string) (fi os.FileInfo, err error) {
+	if fi, err = os.Stat(path); os.IsNotExist(err) {
+		if path, _, err = resolveRecord(path); err == nil {
+			fi, err = os.Stat(path)
+		}
 	}
 	return
 }
@@ -0,0 +1,952 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcelliott/lumber"
+)
+
+func newDB(t testing.TB, o *Options) *Driver {
+	t.Helper()
+	return openDB(t, t.TempDir()+"/db", o)
+}
+
+// openDB opens dir with o, defaulting to a logger that only reports errors.
+func openDB(t testing.TB, dir string, o *Options) *Driver {
+	t.Helper()
+	if o == nil {
+		o = &Options{}
+	}
+	if o.Logger == nil {
+		o.Logger = lumber.NewConsoleLogger(lumber.ERROR)
+	}
+	d, err := New(dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestReadMmap(t *testing.T) {
+	d := newDB(t, &Options{MmapThreshold: 1})
+	if err := d.Write("c", "a", map[string]int{"x": 1}); err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]int
+	if err := d.Read("c", "a", &m); err != nil || m["x"] != 1 {
+		t.Fatal(err, m)
+	}
+	all, err := d.ReadAll("c")
+	if err != nil || len(all) != 1 {
+		t.Fatal(err, all)
+	}
+}
+
+// writeLarge stores a record of roughly size bytes whose bulk is a single
+// string field, so decoding into a small struct costs little beyond the read.
+func writeLarge(b *testing.B, d *Driver, size int) {
+	b.Helper()
+	rec := map[string]interface{}{"N": 1, "Pad": strings.Repeat("x", size)}
+	if err := d.Write("c", "large", rec); err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(size))
+	b.ReportAllocs()
+	b.ResetTimer()
+}
+
+func BenchmarkReadLarge(b *testing.B) {
+	for _, bc := range []struct {
+		name      string
+		threshold int64
+	}{{"ReadFile", 0}, {"Mmap", 1 << 20}} {
+		b.Run(bc.name, func(b *testing.B) {
+			d := newDB(b, &Options{MmapThreshold: bc.threshold})
+			writeLarge(b, d, 4<<20)
+			for i := 0; i < b.N; i++ {
+				var v struct{ N int }
+				if err := d.Read("c", "large", &v); err != nil || v.N != 1 {
+					b.Fatal(v, err)
+				}
+			}
+		})
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", map[string]int{"x": 2})
+	var m map[string]int
+	if err := d.ReadStream("c", "a", &m); err != nil || m["x"] != 2 {
+		t.Fatal(err, m)
+	}
+}
+
+func BenchmarkReadStreamLarge(b *testing.B) {
+	d := newDB(b, nil)
+	writeLarge(b, d, 4<<20)
+	for i := 0; i < b.N; i++ {
+		var v struct{ N int }
+		if err := d.ReadStream("c", "large", &v); err != nil || v.N != 1 {
+			b.Fatal(v, err)
+		}
+	}
+}
+
+func TestReadRaw(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", map[string]int{"x": 2})
+	b, err := d.ReadRaw("c", "a")
+	if err != nil || string(b) != "{\n\t\"x\": 2\n}\n" {
+		t.Fatalf("%v %q", err, b)
+	}
+}
+
+func TestWriteRaw(t *testing.T) {
+	d := newDB(t, nil)
+	raw := []byte(`{"b":1,  "a":2}`)
+	if err := d.WriteRaw("c", "a", raw); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := d.ReadRaw("c", "a")
+	if string(b) != string(raw) {
+		t.Fatal(string(b))
+	}
+	if err := d.WriteRaw("c", "a", []byte(`{`)); err == nil {
+		t.Fatal("want err")
+	}
+}
+
+func TestUpdatePreservesUnknownFields(t *testing.T) {
+	d := newDB(t, nil)
+	d.WriteRaw("c", "a", []byte(`{"Name":"x","Extra":true}`))
+	if err := d.Update("c", "a", map[string]interface{}{"Name": "y"}); err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	d.Read("c", "a", &m)
+	if m["Extra"] != true || m["Name"] != "y" {
+		t.Fatal(m)
+	}
+}
+
+func TestNewSameDirectory(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	errs := make(chan error, 20)
+	for i := 0; i < 20; i++ {
+		go func() {
+			_, err := New(dir, &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+			errs <- err
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNewExclusive(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exclusive locks are unix only")
+	}
+	dir := t.TempDir() + "/db"
+	o := &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR), Exclusive: true}
+	a, err := New(dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir, o); err != ErrLocked {
+		t.Fatal(err)
+	}
+	a.Close()
+	b, err := New(dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+}
+
+func TestReadIntoValue(t *testing.T) {
+	d := newDB(t, nil)
+	d.WriteRaw("c", "a", []byte(`{"x":1}`))
+	var m map[string]int
+	var np *map[string]int
+	if d.Read("c", "a", m) == nil || d.Read("c", "a", np) == nil || d.Read("c", "a", nil) == nil {
+		t.Fatal("expected errors for non-pointer and nil targets")
+	}
+	if err := d.Read("c", "a", &m); err != nil || m["x"] != 1 {
+		t.Fatal(err)
+	}
+}
+
+func TestReadAllRaw(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", User{Name: "A"})
+	d.Write("c", "b", User{Name: "B"})
+	raws, err := d.ReadAllRaw("c")
+	if err != nil || len(raws) != 2 {
+		t.Fatal(err)
+	}
+	var u User
+	if json.Unmarshal(raws[1], &u); u.Name != "B" {
+		t.Fatal(u)
+	}
+}
+
+func BenchmarkReadAll(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			d := newDB(b, nil)
+			for i := 0; i < n; i++ {
+				if err := d.Write("c", fmt.Sprint("r", i), i); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if all, err := d.ReadAll("c"); err != nil || len(all) != n {
+					b.Fatal(len(all), err)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteTrailingNewline(t *testing.T) {
+	for _, omit := range []bool{false, true} {
+		d := newDB(t, &Options{OmitTrailingNewline: omit})
+		d.Write("c", "a", 1)
+		b, _ := d.ReadRaw("c", "a")
+		if (b[len(b)-1] == '\n') == omit {
+			t.Fatal(omit, b)
+		}
+	}
+}
+
+func TestCollectionExists(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	if ok, err := d.CollectionExists("c"); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if ok, err := d.CollectionExists("nope"); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestDeleteNotFound(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Delete("c", "nope"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	d.Write("c", "a", 1)
+	os.Symlink(d.dir+"/c/a.json", d.dir+"/c/l.json")
+	if err := d.Delete("c", "l"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.dir + "/c/a.json"); err != nil {
+		t.Fatal("target removed")
+	}
+}
+
+func TestDeleteEmptyResource(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("user", "a", 1)
+	d.Write("user/someSubdir", "b", 1)
+	if err := d.Delete("user", "someSubdir"); err == nil {
+		t.Fatal("want err")
+	}
+	if err := d.Read("user", "a", new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DropCollection("user"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.CollectionExists("user"); ok {
+		t.Fatal("collection still exists after DropCollection")
+	}
+}
+
+func TestWriteN(t *testing.T) {
+	d := newDB(t, nil)
+	n, err := d.WriteN("c", "a", User{Name: "x"})
+	fi, _ := os.Stat(d.dir + "/c/a.json")
+	if err != nil || int64(n) != fi.Size() {
+		t.Fatal(n, err)
+	}
+}
+
+func TestNestedCollections(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Write("users/2024/active", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	d.Write("users/2024/active", "b", 1)
+	if n, err := d.Count("users/2024/active"); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	all, err := d.ReadAll("users/2024/active")
+	if len(all) != 2 || err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Delete("users/2024/active", "a"); err != nil {
+		t.Fatal(err)
+	}
+	c, _ := d.Collections()
+	if len(c) != 1 || c[0] != "users" {
+		t.Fatal(c)
+	}
+	for _, bad := range []string{"../x", "a/../../b", "/abs", "a//b", "a/"} {
+		if d.Write(bad, "r", 1) == nil {
+			t.Fatal(bad)
+		}
+	}
+	if d.Write("c", "../r", 1) == nil {
+		t.Fatal("expected an error for a traversing resource")
+	}
+}
+
+func TestCollectionsRecursive(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users/2024/active", "a", 1)
+	d.Write("users/2023", "a", 1)
+	d.Write("misc", "a", 1)
+	c, err := d.CollectionsRecursive()
+	if err != nil || fmt.Sprint(c) != "[misc users/2023 users/2024/active]" {
+		t.Fatal(c, err)
+	}
+}
+
+func TestTouch(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(d.dir+"/c/a.json", old, old)
+	if err := d.Touch("c", "a"); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(d.dir + "/c/a.json")
+	if !fi.ModTime().After(old.Add(time.Minute)) {
+		t.Fatal("modification time not updated", fi.ModTime())
+	}
+	if !errors.Is(d.Touch("c", "z"), ErrRecordNotFound) {
+		t.Fatal("expected ErrRecordNotFound")
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	if _, err := New(t.TempDir(), &Options{LogLevel: "error"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(t.TempDir(), &Options{LogLevel: "loud"}); err == nil {
+		t.Fatal("expected an error for an unknown log level")
+	}
+}
+
+func TestReadNotFound(t *testing.T) {
+	d := newDB(t, nil)
+	var v int
+	err := d.Read("c", "a", &v)
+	if !errors.Is(err, ErrRecordNotFound) || !os.IsNotExist(errors.Unwrap(err)) && !errors.Is(err, fs.ErrNotExist) {
+		t.Fatal(err)
+	}
+	d.Write("c", "a", 1)
+	os.Chmod(d.dir+"/c/a.json", 0)
+	defer os.Chmod(d.dir+"/c/a.json", 0644)
+	if os.Getuid() != 0 {
+		if err := d.Read("c", "a", &v); errors.Is(err, ErrRecordNotFound) || !errors.Is(err, fs.ErrPermission) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestEncodeKeys(t *testing.T) {
+	d := newDB(t, &Options{EncodeKeys: true})
+	keys := []string{"a/b", "with space", "x:y", "..", "_z"}
+	for _, k := range keys {
+		if err := d.Write("c", k, k); err != nil {
+			t.Fatal(k, err)
+		}
+	}
+	for _, k := range keys {
+		var v string
+		if err := d.Read("c", k, &v); err != nil || v != k {
+			t.Fatal(k, v, err)
+		}
+	}
+	m, err := d.ReadAllWithKeys("c")
+	if err != nil || len(m) != len(keys) {
+		t.Fatal(m, err)
+	}
+	for _, k := range keys {
+		if _, ok := m[k]; !ok {
+			t.Fatal(k, m)
+		}
+	}
+	if err := d.Delete("c", "a/b"); err != nil {
+		t.Fatal(err)
+	}
+	ents, _ := os.ReadDir(d.dir + "/c")
+	if len(ents) != 4 {
+		t.Fatal(ents)
+	}
+}
+
+func TestNewCreated(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	a, err := New(dir, &Options{LogLevel: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(dir, &Options{LogLevel: "error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !a.WasCreated() || b.WasCreated() {
+		t.Fatal(a.WasCreated(), b.WasCreated())
+	}
+}
+
+func TestNormalizeCollection(t *testing.T) {
+	d := newDB(t, &Options{NormalizeName: strings.ToLower})
+	d.Write("Users", "a", 1)
+	d.Write("users", "b", 1)
+	if n, _ := d.Count("USERS"); n != 2 {
+		t.Fatal(n)
+	}
+	d2 := newDB(t, &Options{NormalizeName: strings.TrimSpace})
+	if err := d2.DropCollection("   "); err == nil {
+		t.Fatal("expected an error for a blank collection")
+	}
+	if err := d2.Write("c", "  ", 1); err != nil {
+		t.Fatal("resource not normalized by default", err)
+	}
+	d3 := newDB(t, &Options{NormalizeName: strings.TrimSpace, NormalizeResources: true})
+	if err := d3.Write("c", "  ", 1); err == nil {
+		t.Fatal("expected an error for a blank resource")
+	}
+}
+
+func TestReadAllSkipsSubdirectories(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("user", "a", 1)
+	d.Write("user", "b", 1)
+	d.Write("user/sub", "c", 1)
+	os.WriteFile(d.dir+"/user/_meta.json", []byte("{}"), 0644)
+	all, err := d.ReadAll("user")
+	if err != nil || len(all) != 2 {
+		t.Fatal(all, err)
+	}
+	raw, err := d.ReadAllRaw("user")
+	if err != nil || len(raw) != 2 {
+		t.Fatal(raw, err)
+	}
+}
+
+func TestReadUseNumber(t *testing.T) {
+	d := newDB(t, &Options{UseNumber: true})
+	d.WriteRaw("c", "a", []byte(`{"id":12345678901234567890}`))
+	var m map[string]interface{}
+	d.Read("c", "a", &m)
+	if n, ok := m["id"].(json.Number); !ok || n.String() != "12345678901234567890" {
+		t.Fatal(m)
+	}
+	d.Update("c", "a", map[string]interface{}{"x": 1})
+	b, _ := d.ReadRaw("c", "a")
+	if !strings.Contains(string(b), "12345678901234567890") {
+		t.Fatal(string(b))
+	}
+}
+
+func TestSub(t *testing.T) {
+	d := newDB(t, nil)
+	sub, err := d.Sub("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Write("invoices", "1", 42)
+	var v int
+	if err := d.Read("billing/invoices", "1", &v); err != nil || v != 42 {
+		t.Fatal(err, v)
+	}
+	if _, err := d.Sub("../x"); err == nil {
+		t.Fatal("expected an error for a traversing prefix")
+	}
+}
+
+func TestWriteWith(t *testing.T) {
+	d := newDB(t, nil)
+	err := d.WriteWith("c", "a", map[string]string{"h": "<b>&</b>"}, func(v interface{}) ([]byte, error) {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		err := enc.Encode(v)
+		return buf.Bytes(), err
+	})
+	b, _ := d.ReadRaw("c", "a")
+	if err != nil || !strings.Contains(string(b), "<b>&</b>") {
+		t.Fatal(err, string(b))
+	}
+}
+
+func TestWriteEscapeHTML(t *testing.T) {
+	for _, omit := range []bool{false, true} {
+		d := newDB(t, &Options{DisableHTMLEscape: true, OmitTrailingNewline: omit})
+		d.Write("c", "a", map[string]string{"h": "<b>"})
+		b, _ := d.ReadRaw("c", "a")
+		want := "{\n\t\"h\": \"<b>\"\n}"
+		if !omit {
+			want += "\n"
+		}
+		if string(b) != want {
+			t.Fatalf("%q", b)
+		}
+	}
+	d := newDB(t, nil)
+	d.Write("c", "a", map[string]string{"h": "<b>"})
+	b, _ := d.ReadRaw("c", "a")
+	if strings.Contains(string(b), `<`) {
+		t.Fatal(string(b))
+	}
+}
+
+func TestResourceCollectionCollision(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c/foo", "x", 1)
+	if err := d.Write("c", "foo", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+	d.Write("c", "bar", 1)
+	if err := d.Write("c/bar", "x", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+	if err := d.Create("c/bar/deep", "x", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateResource(t *testing.T) {
+	re := regexp.MustCompile(`^[a-z0-9-]{1,16}$`)
+	bad := errors.New("bad key")
+	d := newDB(t, &Options{ValidateResource: func(s string) error {
+		if !re.MatchString(s) {
+			return bad
+		}
+		return nil
+	}})
+	if err := d.Write("c", "ok-1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "Not OK", 1); !errors.Is(err, bad) || !errors.Is(err, ErrInvalidResource) {
+		t.Fatal(err)
+	}
+	if err := d.Read("c", "Not OK", new(int)); !errors.Is(err, bad) {
+		t.Fatal(err)
+	}
+	if err := d.Write("c", "..", 1); errors.Is(err, bad) || err == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadDuringWrite(t *testing.T) {
+	d := newDB(t, nil)
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; ; n++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := d.Write("hot/new", "r", map[string]int{"n": n}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	seen := 0
+	for i := 0; i < 2000; i++ {
+		var v map[string]int
+		err := d.Read("hot/new", "r", &v)
+		if errors.Is(err, ErrRecordNotFound) && seen == 0 {
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen++
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestPrepareCollection(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.PrepareCollection("a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := d.CollectionExists("a/b"); !ok {
+		t.Fatal("missing")
+	}
+	d.Write("x", "r", 1)
+	if err := d.PrepareCollection("x/r/y"); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+}
+
+// The cold and warm write benchmarks differ only in whether each collection
+// was prepared ahead of the timed first write.
+func BenchmarkWriteColdCollection(b *testing.B) {
+	d := newDB(b, nil)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := d.Write(fmt.Sprint("c", i), "r", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteWarmCollection(b *testing.B) {
+	d := newDB(b, nil)
+	for i := 0; i < b.N; i++ {
+		if err := d.PrepareCollection(fmt.Sprint("c", i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := d.Write(fmt.Sprint("c", i), "r", 1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	d := newDB(t, &Options{LockTimeout: 30 * time.Millisecond})
+	unlock, _ := d.lock("c")
+	start := time.Now()
+	if err := d.Write("c", "a", 1); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal(err)
+	}
+	if err := d.Read("c", "a", new(int)); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 60*time.Millisecond {
+		t.Fatal("too fast")
+	}
+	unlock()
+	if err := d.Write("c", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTryWrite(t *testing.T) {
+	d := newDB(t, nil)
+	unlock, _ := d.lock("c")
+	if ok, err := d.TryWrite("c", "a", 1); ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	unlock()
+	if ok, err := d.TryWrite("c", "a", 1); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestUpsertWith(t *testing.T) {
+	d := newDB(t, nil)
+	sum := func(a, b json.RawMessage) (json.RawMessage, error) {
+		var x, y struct{ N int }
+		json.Unmarshal(a, &x)
+		json.Unmarshal(b, &y)
+		return json.Marshal(struct{ N int }{x.N + y.N})
+	}
+	for i := 0; i < 3; i++ {
+		if err := d.UpsertWith("c", "a", map[string]int{"N": 2}, sum); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var v struct{ N int }
+	d.Read("c", "a", &v)
+	if v.N != 6 {
+		t.Fatal(v)
+	}
+	bad := func(a, b json.RawMessage) (json.RawMessage, error) { return json.RawMessage("{"), nil }
+	if err := d.UpsertWith("c", "a", 1, bad); err == nil {
+		t.Fatal("accepted")
+	}
+}
+
+func TestWriteParentRemoved(t *testing.T) {
+	d := newDB(t, nil)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				d.DropCollection("p")
+			}
+		}
+	}()
+	for i := 0; i < 2000; i++ {
+		if err := d.Write("p/n", "r", i); err != nil {
+			if !strings.Contains(err.Error(), "removed during the write") {
+				t.Error(err)
+			}
+		}
+	}
+	close(stop)
+	<-done
+}
+
+func TestReadAllResults(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	d.Write("c", "c", 3)
+	os.WriteFile(filepath.Join(d.dir, "c", "b.json"), []byte("{broken"), 0644)
+	rs, err := d.ReadAllResults("c")
+	if err != nil || len(rs) != 3 || rs[0].Err != nil || rs[1].Err == nil || rs[2].Err != nil || string(rs[2].Raw) != "3\n" {
+		t.Fatal(rs, err)
+	}
+	if _, err := d.ReadAllResults("nope"); err == nil {
+		t.Fatal("no error")
+	}
+}
+
+func TestReadMap(t *testing.T) {
+	d := newDB(t, &Options{UseNumber: true})
+	d.Write("users", "John", User{Name: "John", Age: "23", Address: Address{City: "bangalore", Pincode: "410013"}})
+	m, err := d.ReadMap("users", "John")
+	if err != nil || m["Address"].(map[string]interface{})["City"] != "bangalore" {
+		t.Fatal(m, err)
+	}
+	if _, ok := m["Age"].(json.Number); !ok {
+		t.Fatalf("%T", m["Age"])
+	}
+	if _, err := d.ReadMap("users", "nope"); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	ms, err := d.ReadAllMaps("users")
+	if err != nil || len(ms) != 1 {
+		t.Fatal(ms, err)
+	}
+}
+
+func TestWriteCollectionIsFile(t *testing.T) {
+	d := newDB(t, nil)
+	os.WriteFile(filepath.Join(d.dir, "x"), []byte("hi"), 0644)
+	err := d.Write("x", "a", 1)
+	if !errors.Is(err, ErrConflict) || !strings.Contains(err.Error(), "a file with that name already exists") {
+		t.Fatal(err)
+	}
+	if err := d.Write("x/y", "a", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("exclusive locks are unix only")
+	}
+	dir := t.TempDir() + "/db"
+	lg := lumber.NewConsoleLogger(lumber.ERROR)
+	a, err := New(dir, &Options{Logger: lg, Exclusive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(dir + "/_lock")
+	if !strings.Contains(string(b), fmt.Sprintf(`"PID":%d`, os.Getpid())) {
+		t.Fatal(string(b))
+	}
+	// A live holder is never broken.
+	if _, err := New(dir, &Options{Logger: lg, Exclusive: true, BreakStaleLock: true}); err != ErrLocked {
+		t.Fatal(err)
+	}
+	// Pretend the holder crashed: the lock stays held but its PID is gone.
+	host, _ := os.Hostname()
+	os.WriteFile(dir+"/_lock", []byte(fmt.Sprintf(`{"PID":%d,"Host":%q}`, 1<<22+12345, host)), 0644)
+	if _, err := New(dir, &Options{Logger: lg, Exclusive: true}); err != ErrLocked {
+		t.Fatal(err)
+	}
+	c, err := New(dir, &Options{Logger: lg, Exclusive: true, BreakStaleLock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	a.Close()
+}
+
+func TestReadAllLimit(t *testing.T) {
+	d := newDB(t, &Options{Sharding: 1})
+	for i := 0; i < 10; i++ {
+		d.Write("c", fmt.Sprintf("r%d", i), i)
+	}
+	recs, err := d.ReadAllLimit("c", 3)
+	if err != nil || len(recs) != 3 || strings.TrimSpace(recs[0]) != "0" || strings.TrimSpace(recs[2]) != "2" {
+		t.Fatal(recs, err)
+	}
+	if recs, _ = d.ReadAllLimit("c", 0); len(recs) != 10 {
+		t.Fatal(len(recs))
+	}
+	if recs, _ = d.ReadAllLimit("c", 50); len(recs) != 10 {
+		t.Fatal(len(recs))
+	}
+}
+
+func TestAutoCreateOnRead(t *testing.T) {
+	strict := newDB(t, nil)
+	if _, err := strict.ReadAll("nope"); err == nil {
+		t.Fatal("expected an error for a missing collection")
+	}
+	if _, err := strict.Count("nope"); err == nil {
+		t.Fatal("expected an error for a missing collection")
+	}
+	d := newDB(t, &Options{AutoCreateOnRead: true})
+	all, err := d.ReadAll("nope")
+	if err != nil || all == nil || len(all) != 0 {
+		t.Fatal(all, err)
+	}
+	if raws, err := d.ReadAllRaw("nope"); err != nil || len(raws) != 0 {
+		t.Fatal(err)
+	}
+	if rs, err := d.ReadAllResults("nope"); err != nil || len(rs) != 0 {
+		t.Fatal(err)
+	}
+	if n, err := d.Count("nope"); err != nil || n != 0 {
+		t.Fatal(err)
+	}
+	if l, err := d.List("nope"); err != nil || len(l) != 0 {
+		t.Fatal(err)
+	}
+	if err := d.Read("nope", "a", new(int)); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	if ok, _ := d.CollectionExists("nope"); ok {
+		t.Fatal("read created the collection")
+	}
+}
+
+type infoLog struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *infoLog) Fatal(string, ...interface{}) {}
+
+func (l *infoLog) Error(string, ...interface{}) {}
+
+func (l *infoLog) Warn(string, ...interface{}) {}
+
+func (l *infoLog) Debug(string, ...interface{}) {}
+
+func (l *infoLog) Trace(string, ...interface{}) {}
+
+func (l *infoLog) Info(f string, a ...interface{}) {
+	l.mu.Lock()
+	l.lines = append(l.lines, fmt.Sprintf(f, a...))
+	l.mu.Unlock()
+}
+
+func TestDryRun(t *testing.T) {
+	log := &infoLog{}
+	dir := t.TempDir()
+	w := openDB(t, dir, nil)
+	w.Write("c", "a", 1)
+	w.Write("c/n", "b", 2)
+	w.Delete("c", "a")
+	w.Write("c", "a", 1)
+	d := openDB(t, dir, &Options{DryRun: true, Logger: log, Tombstones: true})
+	if err := d.DropCollection("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ReplaceCollection("c", map[string]interface{}{"z": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.PurgeTombstones(time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := w.Exists("c/n", "b"); !ok {
+		t.Fatal("dropped")
+	}
+	if !strings.Contains(log.lines[0], "a, n/b") || !strings.Contains(log.lines[1], ": a") {
+		t.Fatal(log.lines)
+	}
+}
+
+func TestWriteNStoredSize(t *testing.T) {
+	for _, o := range []*Options{nil, {Envelope: true}, {CompressThreshold: 10}, {Dedup: true}} {
+		d := newDB(t, o)
+		n, err := d.WriteN("c", "a", map[string]string{"v": strings.Repeat("x", 300)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		path, _, _ := resolveRecord(filepath.Join(d.dir, "c", "a.json"))
+		fi, _ := os.Stat(path)
+		if int64(n) != fi.Size() {
+			t.Fatalf("%+v: n=%d size=%d", o, n, fi.Size())
+		}
+	}
+}
+
+func TestTryWriteLockStats(t *testing.T) {
+	d := newDB(t, &Options{LockStats: true})
+	unlock, _ := d.lock("c")
+	ok, err := d.TryWrite("c", "a", 1)
+	unlock()
+	if ok || err != nil {
+		t.Fatal(ok, err)
+	}
+	if s := d.LockStats()["c"]; s.Contended != 1 {
+		t.Fatalf("%+v", s)
+	}
+	if ok, err := d.TryWrite("c", "a", 1); !ok || err != nil {
+		t.Fatal(ok, err)
+	}
+}
+
+func TestWriteReservedMeta(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Write("c", "_meta", "garbage"); err == nil {
+		t.Fatal("wrote _meta")
+	}
+	e := newDB(t, &Options{EncodeKeys: true})
+	if err := e.Write("c", "_meta", 1); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := e.Count("c"); n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestWriteReservedName(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Write("c", "_hidden", 1); err == nil {
+		t.Fatal("wrote _hidden")
+	}
+	if _, err := d.WriteBatch("c", map[string]interface{}{"_x": 1}); err == nil {
+		t.Fatal("batch wrote _x")
+	}
+}
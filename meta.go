@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type dbMeta struct {
+	Version string
+	Created time.Time
+}
+
+// Version reports the version of the driver code.
+func (d *Driver) Version() string {
+	return Version
+}
+
+func writeMeta(dir string) error {
+	b, err := json.MarshalIndent(dbMeta{Version: Version, Created: time.Now().UTC()}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	b = append(b, byte('\n'))
+
+	path := filepath.Join(dir, "_meta.json")
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(path+".tmp", path)
+}
+
+// checkMeta warns when the database was created by a newer driver than the
+// one opening it. Databases predating _meta.json are left alone.
+func (d *Driver) checkMeta() {
+	b, err := os.ReadFile(filepath.Join(d.dir, "_meta.json"))
+	if err != nil {
+		return
+	}
+
+	var meta dbMeta
+	if err := json.Unmarshal(b, &meta); err != nil {
+		d.log.Warn("Unable to parse '%s' metadata: %v \n", d.dir, err)
+		return
+	}
+
+	if compareVersions(meta.Version, Version) > 0 {
+		d.log.Warn("Database '%s' was created by version %s, newer than running version %s \n", d.dir, meta.Version, Version)
+	}
+}
+
+// compareVersions compares dotted numeric versions, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+
+	return 0
+}
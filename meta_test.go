@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+type capLog struct {
+	lumber.Logger
+	warns []string
+}
+
+func (c *capLog) Warn(f string, v ...interface{}) { c.warns = append(c.warns, f) }
+
+func TestVersionMetadata(t *testing.T) {
+	dir := t.TempDir() + "/db"
+	d := openDB(t, dir, nil)
+	if d.Version() != Version {
+		t.Fatal(d.Version())
+	}
+	os.WriteFile(dir+"/_meta.json", []byte(`{"Version":"9.0.0"}`), 0644)
+	l := &capLog{Logger: lumber.NewConsoleLogger(lumber.ERROR)}
+	New(dir, &Options{Logger: l})
+	if len(l.warns) != 1 {
+		t.Fatal(l.warns)
+	}
+	if compareVersions("1.0.0", "1.0") != 0 || compareVersions("1.2", "1.10") != -1 {
+		t.Fatal("versions not compared numerically")
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Migrate rewrites every record in collection with the bytes returned by fn,
+// holding the collection lock for the whole run. Records fn hands back
+// unchanged are not rewritten. By default the first failing record stops the
+// migration; with Options.ContinueOnMigrateError every record is attempted and
+// the failures are returned together.
+//
+// The names of the records that were rewritten are returned; under
+// Options.DryRun nothing is written and they are the records that would be.
+func (d *Driver) Migrate(collection string, fn func(raw json.RawMessage) (json.RawMessage, error)) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to migrate!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	if _, err := stat(dir); err != nil {
+		return nil, err
+	}
+
+	resources, err := d.listResources(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		migrated []string
+		errs     []error
+	)
+
+	for _, resource := range resources {
+		changed, err := d.migrateRecord(collection, resource, fn)
+		if err != nil {
+			err = fmt.Errorf("Migration failed for '%s': %w", resource, err)
+			if !d.opts.ContinueOnMigrateError {
+				return migrated, err
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		if changed {
+			migrated = append(migrated, resource)
+		}
+	}
+
+	if d.opts.DryRun {
+		d.logDryRun("migrate", collection, migrated)
+	}
+
+	return migrated, errors.Join(errs...)
+}
+
+func (d *Driver) migrateRecord(collection string, resource string, fn func(raw json.RawMessage) (json.RawMessage, error)) (bool, error) {
+	b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		return false, err
+	}
+
+	out, err := fn(b)
+	if err != nil {
+		return false, err
+	}
+
+	if bytes.Equal(out, b) {
+		return false, nil
+	}
+
+	if !json.Valid(out) {
+		return false, errors.New("Invalid JSON - migration produced a malformed record!")
+	}
+
+	if d.opts.DryRun {
+		return true, nil
+	}
+
+	return true, d.writeFile(collection, resource, out)
+}
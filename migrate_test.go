@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	d := newDB(t, nil)
+	d.WriteRaw("user", "a", []byte(`{"Contact":"1"}`))
+	d.WriteRaw("user", "b", []byte(`{"Phone":"2"}`))
+	_, err := d.Migrate("user", func(raw json.RawMessage) (json.RawMessage, error) {
+		m := map[string]interface{}{}
+		json.Unmarshal(raw, &m)
+		if _, ok := m["Contact"]; !ok {
+			return raw, nil
+		}
+		m["Phone"] = m["Contact"]
+		delete(m, "Contact")
+		return json.Marshal(m)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := d.ReadRaw("user", "a")
+	if string(b) != `{"Phone":"1"}` {
+		t.Fatal(string(b))
+	}
+}
+
+func TestMigrateDryRun(t *testing.T) {
+	d := newDB(t, &Options{DryRun: true})
+	d.WriteRaw("user", "a", []byte(`{"x":1}`))
+	d.WriteRaw("user", "b", []byte(`{"y":1}`))
+	got, err := d.Migrate("user", func(raw json.RawMessage) (json.RawMessage, error) {
+		if string(raw) == `{"x":1}` {
+			return []byte(`{"x":2}`), nil
+		}
+		return raw, nil
+	})
+	b, _ := d.ReadRaw("user", "a")
+	if err != nil || len(got) != 1 || got[0] != "a" || string(b) != `{"x":1}` {
+		t.Fatal(err, got, string(b))
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// mmapFile falls back to a plain read on platforms without mmap support.
+func mmapFile(path string) ([]byte, func(), error) {
+	b, err := os.ReadFile(path)
+	return b, func() {}, err
+}
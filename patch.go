@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// patchOp is one operation of an RFC 6902 JSON Patch.
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// ApplyPatch applies an RFC 6902 JSON Patch, an array of add, remove,
+// replace, move, copy and test operations, to a record and writes the result
+// under the collection lock. The operations apply all or not at all: a failed
+// test fails with ErrConflict, which gives optimistic concurrency, and a path
+// that doesn't resolve with ErrNoSuchField.
+func (d *Driver) ApplyPatch(collection string, resource string, patch json.RawMessage) error {
+	var ops []patchOp
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return fmt.Errorf("Invalid JSON Patch - %v", err)
+	}
+
+	return d.editRecord(collection, resource, func(doc interface{}) (interface{}, error) {
+		for i, op := range ops {
+			var err error
+			if doc, err = d.applyPatchOp(doc, op); err != nil {
+				return nil, fmt.Errorf("%w (operation %d, %s '%s')", err, i, op.Op, op.Path)
+			}
+		}
+		return doc, nil
+	})
+}
+
+func (d *Driver) applyPatchOp(doc interface{}, op patchOp) (interface{}, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("Invalid JSON Patch - '%s' needs a value!", op.Op)
+		}
+		if err := d.unmarshal(op.Value, &value); err != nil {
+			return nil, err
+		}
+	}
+
+	switch op.Op {
+	case "add":
+		return addPointer(doc, path, value)
+
+	case "remove":
+		if len(path) == 0 {
+			return nil, errors.New("Invalid JSON Patch - unable to remove the whole record, use Delete!")
+		}
+		return deletePointer(doc, path)
+
+	case "replace":
+		if _, err := getPointer(doc, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if doc, err = deletePointer(doc, path); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, err
+		}
+
+		if value, err = getPointer(doc, from); err != nil {
+			return nil, err
+		}
+
+		if op.Op == "copy" {
+			if value, err = d.cloneValue(value); err != nil {
+				return nil, err
+			}
+			return addPointer(doc, path, value)
+		}
+
+		if op.Path == op.From {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("Invalid JSON Patch - unable to move '%s' into itself!", op.From)
+		}
+		if doc, err = deletePointer(doc, from); err != nil {
+			return nil, err
+		}
+		return addPointer(doc, path, value)
+
+	case "test":
+		current, err := getPointer(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("%w: test failed", ErrConflict)
+		}
+		return doc, nil
+	}
+
+	return nil, fmt.Errorf("Invalid JSON Patch - unknown operation '%s'!", op.Op)
+}
+
+// ApplyMergePatch applies an RFC 7386 JSON Merge Patch to a record and writes
+// the result under the collection lock: objects merge recursively, null
+// removes a key and any other value replaces what was there.
+func (d *Driver) ApplyMergePatch(collection string, resource string, patch json.RawMessage) error {
+	var p interface{}
+	if err := d.unmarshal(patch, &p); err != nil {
+		return fmt.Errorf("Invalid JSON Merge Patch - %v", err)
+	}
+
+	return d.editRecord(collection, resource, func(doc interface{}) (interface{}, error) {
+		return mergePatch(doc, p), nil
+	})
+}
+
+// mergePatch is the MergePatch function of RFC 7386.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = mergePatch(t[name], value)
+	}
+
+	return t
+}
+
+// getPointer returns the value tokens lead to below node.
+func getPointer(node interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			child, ok := n[token]
+			if !ok {
+				return nil, ErrNoSuchField
+			}
+			node = child
+
+		case []interface{}:
+			i, ok := arrayIndex(token, len(n))
+			if !ok {
+				return nil, ErrNoSuchField
+			}
+			node = n[i]
+
+		default:
+			return nil, ErrNoSuchField
+		}
+	}
+
+	return node, nil
+}
+
+// addPointer is the RFC 6902 add: unlike setPointer the parent must exist,
+// and in an array the value is inserted rather than replacing an element.
+func addPointer(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token, last := tokens[0], len(tokens) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if last {
+			n[token] = value
+			return n, nil
+		}
+
+		child, ok := n[token]
+		if !ok {
+			return nil, ErrNoSuchField
+		}
+
+		child, err := addPointer(child, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		if last {
+			if token == "-" {
+				return append(n, value), nil
+			}
+
+			i, ok := arrayIndex(token, len(n)+1)
+			if !ok {
+				return nil, ErrNoSuchField
+			}
+
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+
+		i, ok := arrayIndex(token, len(n))
+		if !ok {
+			return nil, ErrNoSuchField
+		}
+
+		child, err := addPointer(n[i], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+
+	return nil, ErrNoSuchField
+}
+
+// cloneValue deep-copies a generically decoded value.
+func (d *Driver) cloneValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var clone interface{}
+	if err := d.unmarshal(b, &clone); err != nil {
+		return nil, err
+	}
+
+	return clone, nil
+}
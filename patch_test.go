@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	d := newDB(t, nil)
+	d.WriteRaw("c", "a", []byte(`{"n":1,"l":[1,3],"o":{"k":"v"}}`))
+	err := d.ApplyPatch("c", "a", []byte(`[
+		{"op":"test","path":"/n","value":1},
+		{"op":"replace","path":"/n","value":2},
+		{"op":"add","path":"/l/1","value":2},
+		{"op":"add","path":"/new","value":{"x":true}},
+		{"op":"remove","path":"/o/k"},
+		{"op":"copy","from":"/l","path":"/l2"},
+		{"op":"move","from":"/new","path":"/o/moved"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	d.Read("c", "a", &m)
+	want := map[string]interface{}{"n": 2.0, "l": []interface{}{1.0, 2.0, 3.0}, "l2": []interface{}{1.0, 2.0, 3.0}, "o": map[string]interface{}{"moved": map[string]interface{}{"x": true}}}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatal(m)
+	}
+	before, _ := d.ReadRaw("c", "a")
+	err = d.ApplyPatch("c", "a", []byte(`[{"op":"replace","path":"/n","value":9},{"op":"test","path":"/n","value":1}]`))
+	if !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+	after, _ := d.ReadRaw("c", "a")
+	if !bytes.Equal(before, after) {
+		t.Fatal("partial patch written")
+	}
+	if err := d.ApplyPatch("c", "a", []byte(`[{"op":"add","path":"/zz/y","value":1}]`)); !errors.Is(err, ErrNoSuchField) {
+		t.Fatal(err)
+	}
+	if err := d.ApplyPatch("c", "a", []byte(`[{"op":"move","from":"/o","path":"/o/x"}]`)); err == nil {
+		t.Fatal("expected an error moving a value into itself")
+	}
+}
+
+func TestApplyMergePatch(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users", "john", User{Name: "John", Company: "X", Address: Address{City: "B", State: "K", Pincode: "1"}})
+	err := d.ApplyMergePatch("users", "john", []byte(`{"Company":null,"Address":{"City":"M","State":null},"Tags":{"a":null,"b":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	d.Read("users", "john", &m)
+	if _, ok := m["Company"]; ok {
+		t.Fatal(m)
+	}
+	addr := m["Address"].(map[string]interface{})
+	if addr["City"] != "M" || addr["Pincode"] != 1.0 || addr["State"] != nil || m["Name"] != "John" {
+		t.Fatal(m)
+	}
+	if !reflect.DeepEqual(m["Tags"], map[string]interface{}{"b": 1.0}) {
+		t.Fatal(m["Tags"])
+	}
+	if err := d.ApplyMergePatch("users", "john", []byte(`{`)); err == nil {
+		t.Fatal("expected an error for malformed JSON")
+	}
+}
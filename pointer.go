@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadField decodes into v just the part of a record that the RFC 6901 JSON
+// Pointer names, e.g. /Address/City. Only the objects and arrays along the
+// pointer are decoded, not the whole record. A pointer that doesn't resolve
+// fails with ErrNoSuchField.
+func (d *Driver) ReadField(collection string, resource string, pointer string, v interface{}) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	if err := validateTarget(v); err != nil {
+		return err
+	}
+
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	b, release, err := d.readFile(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		return recordError(collection, resource, err)
+	}
+	defer release()
+
+	field, err := resolvePointer(b, tokens)
+	if err != nil {
+		return fmt.Errorf("%w: '%s' in '%s'", err, pointer, filepath.Join(collection, resource))
+	}
+
+	return d.unmarshal(field, v)
+}
+
+// WriteField sets the part of a record that the RFC 6901 JSON Pointer names
+// to value, creating missing intermediate objects, and writes the record back
+// under the collection lock. The token "-" appends to an array. Like Update,
+// the record goes through a generic map, so unknown fields survive.
+func (d *Driver) WriteField(collection string, resource string, pointer string, value interface{}) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	v, err := d.marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return d.editRecord(collection, resource, func(doc interface{}) (interface{}, error) {
+		return setPointer(doc, tokens, json.RawMessage(v))
+	})
+}
+
+// DeleteField removes the part of a record that the RFC 6901 JSON Pointer
+// names, failing with ErrNoSuchField if there is none.
+func (d *Driver) DeleteField(collection string, resource string, pointer string) error {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return errors.New("Invalid JSON Pointer - unable to delete the whole record, use Delete!")
+	}
+
+	return d.editRecord(collection, resource, func(doc interface{}) (interface{}, error) {
+		return deletePointer(doc, tokens)
+	})
+}
+
+// editRecord decodes an existing record generically, applies edit to it and
+// writes the result back, all under the collection lock.
+func (d *Driver) editRecord(collection string, resource string, edit func(doc interface{}) (interface{}, error)) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+	if err != nil {
+		return recordError(collection, resource, err)
+	}
+
+	var doc interface{}
+	if err := d.unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	if doc, err = edit(doc); err != nil {
+		return fmt.Errorf("%w in '%s'", err, filepath.Join(collection, resource))
+	}
+
+	if b, err = d.marshal(doc); err != nil {
+		return err
+	}
+
+	return d.writeFile(collection, resource, b)
+}
+
+// setPointer sets the value tokens lead to below node and returns the
+// updated node.
+func setPointer(node interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	token := tokens[0]
+
+	switch n := node.(type) {
+	case nil:
+		return setPointer(map[string]interface{}{}, tokens, value)
+
+	case map[string]interface{}:
+		child, err := setPointer(n[token], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		if token == "-" {
+			child, err := setPointer(nil, tokens[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			return append(n, child), nil
+		}
+
+		i, ok := arrayIndex(token, len(n))
+		if !ok {
+			return nil, ErrNoSuchField
+		}
+
+		child, err := setPointer(n[i], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+
+	return nil, ErrNoSuchField
+}
+
+// deletePointer removes the value tokens lead to below node and returns the
+// updated node.
+func deletePointer(node interface{}, tokens []string) (interface{}, error) {
+	token := tokens[0]
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[token]
+		if !ok {
+			return nil, ErrNoSuchField
+		}
+
+		if len(tokens) == 1 {
+			delete(n, token)
+			return n, nil
+		}
+
+		child, err := deletePointer(child, tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[token] = child
+		return n, nil
+
+	case []interface{}:
+		i, ok := arrayIndex(token, len(n))
+		if !ok {
+			return nil, ErrNoSuchField
+		}
+
+		if len(tokens) == 1 {
+			return append(n[:i], n[i+1:]...), nil
+		}
+
+		child, err := deletePointer(n[i], tokens[1:])
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+
+	return nil, ErrNoSuchField
+}
+
+// pointerUnescaper undoes RFC 6901 escaping: ~1 is '/' and ~0 is '~'.
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference
+// tokens. The empty pointer names the whole document and has none.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("Invalid JSON Pointer '%s' - it must be empty or start with '/'!", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = pointerUnescaper.Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// resolvePointer walks tokens down from the JSON document b and returns the
+// value they lead to.
+func resolvePointer(b []byte, tokens []string) (json.RawMessage, error) {
+	v := json.RawMessage(b)
+
+	for _, token := range tokens {
+		switch trimmed := bytes.TrimSpace(v); {
+		case len(trimmed) > 0 && trimmed[0] == '{':
+			var obj map[string]json.RawMessage
+			if err := json.Unmarshal(trimmed, &obj); err != nil {
+				return nil, err
+			}
+
+			next, ok := obj[token]
+			if !ok {
+				return nil, ErrNoSuchField
+			}
+			v = next
+
+		case len(trimmed) > 0 && trimmed[0] == '[':
+			var arr []json.RawMessage
+			if err := json.Unmarshal(trimmed, &arr); err != nil {
+				return nil, err
+			}
+
+			i, ok := arrayIndex(token, len(arr))
+			if !ok {
+				return nil, ErrNoSuchField
+			}
+			v = arr[i]
+
+		default:
+			return nil, ErrNoSuchField
+		}
+	}
+
+	return v, nil
+}
+
+// arrayIndex parses a pointer token as an index into an array of n elements.
+// RFC 6901 allows no sign and no leading zeros.
+func arrayIndex(token string, n int) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, false
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i >= n {
+		return 0, false
+	}
+
+	return i, true
+}
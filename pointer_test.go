@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestReadField(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users", "john", User{Name: "John", Address: Address{City: "Bangalore", Pincode: "1"}})
+	d.WriteRaw("c", "x", []byte(`{"a/b":{"m~n":[1,{"k":"v"}]}}`))
+	var city string
+	if err := d.ReadField("users", "john", "/Address/City", &city); err != nil || city != "Bangalore" {
+		t.Fatal(city, err)
+	}
+	var k string
+	if err := d.ReadField("c", "x", "/a~1b/m~0n/1/k", &k); err != nil || k != "v" {
+		t.Fatal(k, err)
+	}
+	for _, p := range []string{"/Address/Nope", "/Name/x", "/Address/City/0"} {
+		if err := d.ReadField("users", "john", p, &city); !errors.Is(err, ErrNoSuchField) {
+			t.Fatal(p, err)
+		}
+	}
+	if err := d.ReadField("c", "x", "/a~1b/m~0n/01", &k); !errors.Is(err, ErrNoSuchField) {
+		t.Fatal(err)
+	}
+	if err := d.ReadField("users", "john", "Address", &city); err == nil {
+		t.Fatal("expected an error for a pointer without a leading slash")
+	}
+	var all map[string]interface{}
+	if err := d.ReadField("users", "john", "", &all); err != nil || all["Name"] != "John" {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteField(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("users", "john", User{Name: "John", Address: Address{City: "B", Pincode: "1"}})
+	if err := d.WriteField("users", "john", "/Address/Pincode", 560001); err != nil {
+		t.Fatal(err)
+	}
+	var u User
+	d.Read("users", "john", &u)
+	if u.Address.Pincode != "560001" || u.Address.City != "B" {
+		t.Fatal(u)
+	}
+	if err := d.WriteField("users", "john", "/Extra/deep/x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	var s string
+	if d.ReadField("users", "john", "/Extra/deep/x", &s); s != "y" {
+		t.Fatal(s)
+	}
+	if err := d.DeleteField("users", "john", "/Address/Pincode"); err != nil {
+		t.Fatal(err)
+	}
+	var m map[string]interface{}
+	d.ReadField("users", "john", "/Address", &m)
+	if _, ok := m["Pincode"]; ok || m["City"] != "B" {
+		t.Fatal(m)
+	}
+	if err := d.DeleteField("users", "john", "/Address/Pincode"); !errors.Is(err, ErrNoSuchField) {
+		t.Fatal(err)
+	}
+	d.WriteRaw("c", "a", []byte(`{"l":[1,2]}`))
+	d.WriteField("c", "a", "/l/-", 3)
+	d.DeleteField("c", "a", "/l/0")
+	var l []int
+	if d.ReadField("c", "a", "/l", &l); !reflect.DeepEqual(l, []int{2, 3}) {
+		t.Fatal(l)
+	}
+	if err := d.WriteField("c", "nope", "/x", 1); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+}
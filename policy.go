@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Policy holds per-collection rules, persisted in the collection's _meta.json.
+type Policy struct {
+	// AppendOnly makes records immutable once written, for event logs:
+	// overwriting or deleting them fails with ErrImmutable.
+	AppendOnly bool
+
+	// MaxRecords caps the number of records in the collection; zero means no
+	// cap. What happens to an insert into a full collection is decided by
+	// Eviction. Append-only collections never evict.
+	MaxRecords int
+	Eviction   Eviction
+}
+
+// Eviction selects how a collection at its MaxRecords cap takes a new record.
+type Eviction int
+
+const (
+	// EvictOldest removes the least recently modified record to make room.
+	EvictOldest Eviction = iota
+	// RejectWhenFull fails the insert with ErrCollectionFull.
+	RejectWhenFull
+)
+
+type collectionMeta struct {
+	Policy     Policy
+	FieldTypes map[string]string `json:",omitempty"`
+}
+
+// SetCollectionPolicy stores policy for collection, creating the collection
+// if needed.
+func (d *Driver) SetCollectionPolicy(collection string, policy Policy) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to set policy!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := d.collectionMeta(collection)
+	if err != nil {
+		return err
+	}
+
+	meta.Policy = policy
+	if err := d.writeCollectionMeta(collection, meta); err != nil {
+		return err
+	}
+
+	d.forgetCount(collection)
+	return nil
+}
+
+// CollectionPolicy returns the policy in effect for collection.
+func (d *Driver) CollectionPolicy(collection string) (Policy, error) {
+	if collection == "" {
+		return Policy{}, errors.New("Missing Collection - unable to read policy!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return Policy{}, err
+	}
+
+	meta, err := d.collectionMeta(collection)
+	return meta.Policy, err
+}
+
+// collectionMeta loads a collection's metadata, caching it after the first
+// read. A collection without a _meta.json has the zero metadata.
+func (d *Driver) collectionMeta(collection string) (collectionMeta, error) {
+	key := d.normalize(collection)
+
+	d.cacheMutex.Lock()
+	meta, ok := d.metas[key]
+	d.cacheMutex.Unlock()
+
+	if ok {
+		return meta, nil
+	}
+
+	b, err := os.ReadFile(filepath.Join(d.collectionDir(collection), "_meta.json"))
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		// Reading through a file where the collection should be fails with
+		// ENOTDIR; say what is actually in the way.
+		if cerr := d.checkCollectionPath(collection); cerr != nil {
+			return meta, cerr
+		}
+		return meta, err
+	default:
+		if err := json.Unmarshal(b, &meta); err != nil {
+			return meta, fmt.Errorf("Unable to parse metadata of '%s': %w", collection, err)
+		}
+	}
+
+	d.cacheMutex.Lock()
+	d.metas[key] = meta
+	d.cacheMutex.Unlock()
+
+	return meta, nil
+}
+
+// writeCollectionMeta persists meta; callers must hold the collection lock.
+func (d *Driver) writeCollectionMeta(collection string, meta collectionMeta) error {
+	dir := d.collectionDir(collection)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	b = append(b, byte('\n'))
+
+	path := filepath.Join(dir, "_meta.json")
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	d.cacheMutex.Lock()
+	d.metas[d.normalize(collection)] = meta
+	d.cacheMutex.Unlock()
+
+	return nil
+}
+
+// admission applies the collection policy to writing resource: it refuses to
+// overwrite records of append-only collections and, in capped ones, returns
+// how many records must be evicted to make room (see makeRoom). It reports
+// whether the write adds a record that must be counted against the cap once
+// it succeeds (see adjustCount). Callers must hold the collection lock.
+func (d *Driver) admission(collection string, resource string) (added bool, evict int, err error) {
+	meta, err := d.collectionMeta(collection)
+	if err != nil {
+		return false, 0, err
+	}
+
+	policy := meta.Policy
+	if !policy.AppendOnly && policy.MaxRecords <= 0 {
+		return false, 0, nil
+	}
+
+	_, _, err = resolveRecord(d.recordPath(collection, resource) + ".json")
+	switch {
+	case err == nil && policy.AppendOnly:
+		return false, 0, fmt.Errorf("%w '%s'", ErrImmutable, filepath.Join(collection, resource))
+	case err == nil, policy.MaxRecords <= 0:
+		return false, 0, nil
+	case !os.IsNotExist(err):
+		return false, 0, err
+	}
+
+	count, err := d.recordCount(collection)
+	if err != nil {
+		return false, 0, err
+	}
+
+	if count >= policy.MaxRecords {
+		if policy.AppendOnly || policy.Eviction == RejectWhenFull {
+			return false, 0, fmt.Errorf("%w: '%s' holds %d records", ErrCollectionFull, collection, count)
+		}
+		evict = count - policy.MaxRecords + 1
+	}
+
+	return true, evict, nil
+}
+
+// makeRoom evicts the n records admission asked for. Writers call it only
+// once the new record is staged, right before committing it, so a write that
+// fails validation never costs an existing record.
+func (d *Driver) makeRoom(collection string, n int) error {
+	if n == 0 {
+		return nil
+	}
+
+	removed, err := d.evictOldest(collection, n, nil)
+	d.adjustCount(collection, -removed)
+	return err
+}
+
+// evictOldest removes the n least recently modified records in collection,
+// sparing the record paths in keep, and returns how many it removed. The
+// record count is left to the caller.
+func (d *Driver) evictOldest(collection string, n int, keep map[string]bool) (int, error) {
+	entries, err := d.recordFiles(d.collectionDir(collection))
+	if err != nil {
+		return 0, err
+	}
+
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+
+	candidates := make([]candidate, 0, len(entries))
+	for _, e := range entries {
+		if keep[trimRecordExt(e.path)] {
+			continue
+		}
+
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+
+		candidates = append(candidates, candidate{e.path, fi.ModTime()})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].modTime.Before(candidates[j].modTime)
+	})
+
+	removed := 0
+	for _, c := range candidates {
+		if removed == n {
+			break
+		}
+
+		d.log.Debug("Evicting '%s' from full collection '%s' \n", filepath.Base(c.path), collection)
+		if err := os.Remove(c.path); err != nil {
+			return removed, err
+		}
+		removed++
+
+		if _, err := removeBinary(c.path); err != nil {
+			return removed, err
+		}
+
+		if err := d.tombstone(c.path); err != nil {
+			return removed, err
+		}
+	}
+
+	return removed, nil
+}
+
+// recordCount returns the number of records in collection, listing the
+// directory only the first time and tracking inserts and removals after that.
+func (d *Driver) recordCount(collection string) (int, error) {
+	key := d.normalize(collection)
+
+	d.cacheMutex.Lock()
+	count, ok := d.counts[key]
+	d.cacheMutex.Unlock()
+
+	if ok {
+		return count, nil
+	}
+
+	resources, err := d.listResources(d.collectionDir(collection))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	d.cacheMutex.Lock()
+	d.counts[key] = len(resources)
+	d.cacheMutex.Unlock()
+
+	return len(resources), nil
+}
+
+// adjustCount updates the cached record count of collection, if there is one.
+func (d *Driver) adjustCount(collection string, delta int) {
+	key := d.normalize(collection)
+
+	d.cacheMutex.Lock()
+	defer d.cacheMutex.Unlock()
+
+	if count, ok := d.counts[key]; ok {
+		d.counts[key] = count + delta
+	}
+}
+
+// forgetCount drops the cached record count so the next use recounts.
+func (d *Driver) forgetCount(collection string) {
+	d.cacheMutex.Lock()
+	delete(d.counts, d.normalize(collection))
+	d.cacheMutex.Unlock()
+}
+
+// checkRemove fails with ErrImmutable when collection is append-only.
+func (d *Driver) checkRemove(collection string) error {
+	meta, err := d.collectionMeta(collection)
+	if err != nil {
+		return err
+	}
+
+	if meta.Policy.AppendOnly {
+		return fmt.Errorf("%w: collection '%s' is append-only", ErrImmutable, collection)
+	}
+
+	return nil
+}
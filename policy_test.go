@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendOnly(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.SetCollectionPolicy("events", Policy{AppendOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("events", "1", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("events", "1", 2); !errors.Is(err, ErrImmutable) {
+		t.Fatal(err)
+	}
+	if err := d.Delete("events", "1"); !errors.Is(err, ErrImmutable) {
+		t.Fatal(err)
+	}
+	if err := d.DropCollection("events"); !errors.Is(err, ErrImmutable) {
+		t.Fatal(err)
+	}
+	d2 := openDB(t, d.dir, nil)
+	if p, _ := d2.CollectionPolicy("events"); !p.AppendOnly {
+		t.Fatal("not persisted")
+	}
+	if all, _ := d2.ReadAll("events"); len(all) != 1 {
+		t.Fatal(all)
+	}
+	d.SetCollectionPolicy("events", Policy{})
+	if err := d.Delete("events", "1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxRecords(t *testing.T) {
+	d := newDB(t, nil)
+	d.SetCollectionPolicy("cache", Policy{MaxRecords: 2})
+	for i, k := range []string{"a", "b", "c"} {
+		d.Write("cache", k, i)
+		ts := time.Now().Add(time.Duration(i-10) * time.Minute)
+		os.Chtimes(d.dir+"/cache/"+k+".json", ts, ts)
+	}
+	l, _ := d.List("cache")
+	if fmt.Sprint(l) != "[b c]" {
+		t.Fatal(l)
+	}
+	d.Write("cache", "c", 9) // overwrite doesn't evict
+	d.Delete("cache", "b")
+	d.Write("cache", "d", 1)
+	if l, _ := d.List("cache"); fmt.Sprint(l) != "[c d]" {
+		t.Fatal(l)
+	}
+	d.SetCollectionPolicy("r", Policy{MaxRecords: 1, Eviction: RejectWhenFull})
+	d.Write("r", "a", 1)
+	if err := d.Write("r", "b", 1); !errors.Is(err, ErrCollectionFull) {
+		t.Fatal(err)
+	}
+	if err := d.Create("r", "b", 1); !errors.Is(err, ErrCollectionFull) {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxRecordsEviction(t *testing.T) {
+	d := newDB(t, nil)
+	d.SetCollectionPolicy("c", Policy{MaxRecords: 1})
+	d.Write("c", "a", 1)
+	d.Write("c/sub", "x", 1)
+	if err := d.WriteFrom("c", "b", strings.NewReader("{bad")); err == nil {
+		t.Fatal("bad json accepted")
+	}
+	if err := d.Write("c", "sub", 1); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+	if ok, _ := d.Exists("c", "a"); !ok {
+		t.Fatal("a evicted by a failed write")
+	}
+	if err := d.Create("c", "b", 2); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := d.List("c"); !reflect.DeepEqual(list, []string{"b"}) {
+		t.Fatal(list)
+	}
+	if err := d.WriteFrom("c", "s", strings.NewReader("3")); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := d.List("c"); !reflect.DeepEqual(list, []string{"s"}) {
+		t.Fatal(list)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// PublishStrategy selects how a staged temp file is committed to a record's
+// final name.
+type PublishStrategy int
+
+const (
+	// PublishRename renames the temp file over the record, atomically
+	// replacing any previous version.
+	PublishRename PublishStrategy = iota
+	// PublishLinkThenUnlink hard-links the temp file to the record's name and
+	// then unlinks the temp file, for filesystems whose rename is weak. A link
+	// can't replace an existing file, so overwrites still rename, as does
+	// everything on filesystems without hard links (EXDEV, EPERM, ENOTSUP).
+	PublishLinkThenUnlink
+)
+
+// commitFile moves the staged tmpPath to fnlPath using the configured
+// PublishStrategy.
+func (d *Driver) commitFile(tmpPath string, fnlPath string) error {
+	rename := func() error { return os.Rename(tmpPath, fnlPath) }
+
+	if d.opts.PublishStrategy != PublishLinkThenUnlink {
+		return d.retry(rename)
+	}
+
+	err := d.retry(func() error { return os.Link(tmpPath, fnlPath) })
+	switch {
+	case err == nil:
+		return os.Remove(tmpPath)
+	case os.IsExist(err):
+		return d.retry(rename)
+	case linkUnsupported(err):
+		d.log.Debug("Hard links unsupported for '%s', renaming instead: %v \n", fnlPath, err)
+		return d.retry(rename)
+	}
+
+	return err
+}
+
+// writeTemp writes b to a new, uniquely named temp file beside fnlPath and
+// returns its path. The file is removed again if writing it fails.
+func (d *Driver) writeTemp(fnlPath string, b []byte) (string, error) {
+	var tmpPath string
+
+	err := d.retry(func() error {
+		f, err := createTemp(fnlPath)
+		if err != nil {
+			return err
+		}
+		tmpPath = f.Name()
+
+		_, err = f.Write(b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(tmpPath)
+		}
+		return err
+	})
+
+	return tmpPath, err
+}
+
+// createTemp creates a uniquely named temp file in fnlPath's directory, so
+// concurrent writers never share one, with the permissions of a record file.
+// Its name ends in .tmp and so is never mistaken for a record.
+func createTemp(fnlPath string) (*os.File, error) {
+	f, err := os.CreateTemp(filepath.Dir(fnlPath), filepath.Base(fnlPath)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// createExclusive writes b to a new file at path, failing with an error
+// satisfying os.IsExist if there is one already. Unlike linking a temp file
+// into place it briefly exposes a partly written file, so it is only the
+// fallback where hard links are unsupported.
+func createExclusive(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+
+	return err
+}
+
+func linkUnsupported(err error) bool {
+	return errors.Is(err, syscall.EXDEV) ||
+		errors.Is(err, syscall.EPERM) ||
+		errors.Is(err, syscall.ENOTSUP) ||
+		errors.Is(err, errors.ErrUnsupported)
+}
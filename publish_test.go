@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+func TestCreate(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.Create("c", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("c", "a", 2); !errors.Is(err, ErrAlreadyExists) {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	var ok int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if d.Create("c", "b", i) == nil {
+				atomic.AddInt32(&ok, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if ok != 1 {
+		t.Fatal(ok)
+	}
+	ents, _ := os.ReadDir(d.dir + "/c")
+	if len(ents) != 2 {
+		t.Fatal(ents)
+	}
+}
+
+func TestPublishLink(t *testing.T) {
+	d := newDB(t, &Options{PublishStrategy: PublishLinkThenUnlink})
+	for i := 0; i < 3; i++ {
+		if err := d.Write("c", "a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.WriteFrom("c", "s", strings.NewReader("1"))
+	d.WriteBatchAtomic("c", map[string]interface{}{"b": 1})
+	var v int
+	d.Read("c", "a", &v)
+	entries, _ := os.ReadDir(filepath.Join(d.dir, "c"))
+	if v != 2 || len(entries) != 3 {
+		t.Fatal(v, entries)
+	}
+}
+
+func TestTempName(t *testing.T) {
+	d := newDB(t, nil)
+	d2, err := New(d.dir, &Options{Logger: lumber.NewConsoleLogger(lumber.ERROR)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 200)
+	for i := 0; i < 100; i++ {
+		for _, db := range []*Driver{d, d2} {
+			wg.Add(1)
+			go func(db *Driver, i int) {
+				defer wg.Done()
+				errs <- db.Write("u", "same", map[string]int{"i": i})
+			}(db, i)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ents, _ := os.ReadDir(filepath.Join(d.dir, "u"))
+	if len(ents) != 1 || ents[0].Name() != "same.json" {
+		t.Fatal(ents)
+	}
+	fi, _ := os.Stat(filepath.Join(d.dir, "u", "same.json"))
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Fatal(fi.Mode())
+	}
+	if err := d.WriteBatchAtomic("u", map[string]interface{}{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	d.WriteFrom("u", "c", strings.NewReader(`{`))
+	d.Create("u", "d", 1)
+	ents, _ = os.ReadDir(filepath.Join(d.dir, "u"))
+	if len(ents) != 4 {
+		t.Fatal(ents)
+	}
+}
+
+func TestCreateWithoutHardLinks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.json")
+	if err := createExclusive(path, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := createExclusive(path, []byte("2")); !os.IsExist(err) {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "1" {
+		t.Fatal(string(b))
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FindByPrefix returns the records in collection whose resource name starts
+// with prefix. Only the matching files are read.
+func (d *Driver) FindByPrefix(collection string, prefix string) (map[string]json.RawMessage, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	resources, err := d.List(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []string
+	for _, resource := range resources {
+		if strings.HasPrefix(resource, prefix) {
+			matched = append(matched, resource)
+		}
+	}
+
+	return d.readResources(collection, matched)
+}
+
+// ReadRange returns the records whose resource names fall lexically within
+// [startKey, endKey], which suits date or sequence based names. Only the
+// records inside the range are read.
+func (d *Driver) ReadRange(collection string, startKey string, endKey string) (map[string]json.RawMessage, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	resources, err := d.List(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(resources)
+
+	start := sort.SearchStrings(resources, startKey)
+	end := start
+	for end < len(resources) && resources[end] <= endKey {
+		end++
+	}
+
+	return d.readResources(collection, resources[start:end])
+}
+
+// ReadModifiedSince returns the records of collection whose files were
+// modified after since, for incremental sync. Only those records are read.
+func (d *Driver) ReadModifiedSince(collection string, since time.Time) (map[string]json.RawMessage, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := d.recordFiles(d.collectionDir(collection))
+	if err != nil && !d.missingAsEmpty(err) {
+		return nil, err
+	}
+
+	var modified []string
+	for _, e := range entries {
+		fi, err := e.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if fi.ModTime().After(since) {
+			modified = append(modified, d.decodeKey(trimRecordExt(e.Name())))
+		}
+	}
+
+	return d.readResources(collection, modified)
+}
+
+// ListByModTime returns the names of the records in collection ordered by
+// when their files were last modified, oldest first if ascending and newest
+// first otherwise, for "recently updated" views. Records modified at the same
+// time are ordered by name.
+func (d *Driver) ListByModTime(collection string, ascending bool) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to list!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	entries, err := d.recordFiles(d.collectionDir(collection))
+	if err != nil && !d.missingAsEmpty(err) {
+		return nil, err
+	}
+
+	type modified struct {
+		resource string
+		modTime  time.Time
+	}
+
+	records := make([]modified, 0, len(entries))
+	for _, e := range entries {
+		fi, err := e.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records = append(records, modified{d.decodeKey(trimRecordExt(e.Name())), fi.ModTime()})
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime) == ascending
+		}
+		return a.resource < b.resource
+	})
+
+	resources := make([]string, len(records))
+	for i, r := range records {
+		resources[i] = r.resource
+	}
+
+	return resources, nil
+}
+
+// CollectionModTime returns when collection last changed: the latest
+// modification time among its records and its directory, whose time moves
+// when records are added or removed. Compare it with an earlier result to
+// tell cheaply whether anything changed. Under Options.AutoCreateOnRead a
+// missing collection has the zero time.
+func (d *Driver) CollectionModTime(collection string) (time.Time, error) {
+	if collection == "" {
+		return time.Time{}, errors.New("Missing Collection - unable to stat!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return time.Time{}, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	fi, err := os.Stat(dir)
+	switch {
+	case d.missingAsEmpty(err):
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, err
+	}
+	latest := fi.ModTime()
+
+	entries, err := d.recordFiles(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for _, e := range entries {
+		fi, err := e.Info()
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// Exists reports whether collection holds resource. A missing record or
+// collection is not an error.
+func (d *Driver) Exists(collection string, resource string) (bool, error) {
+	found, err := d.ExistsMany(collection, []string{resource})
+	if err != nil {
+		return false, err
+	}
+
+	return found[resource], nil
+}
+
+// ExistsMany reports, for each of resources, whether collection holds it, all
+// under one read lock. Every name is validated before anything is checked.
+func (d *Driver) ExistsMany(collection string, resources []string) (map[string]bool, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to check!")
+	}
+
+	for _, resource := range resources {
+		if err := d.validateCollectionResource(collection, resource); err != nil {
+			return nil, err
+		}
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	found := make(map[string]bool, len(resources))
+	for _, resource := range resources {
+		_, _, err := resolveRecord(d.recordPath(collection, resource) + ".json")
+		switch {
+		case err == nil:
+			found[resource] = true
+		case os.IsNotExist(err):
+			found[resource] = false
+		default:
+			return nil, err
+		}
+	}
+
+	return found, nil
+}
+
+// readResources reads the named records, skipping any that have disappeared
+// since they were listed.
+func (d *Driver) readResources(collection string, resources []string) (map[string]json.RawMessage, error) {
+	records := make(map[string]json.RawMessage, len(resources))
+
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		records[resource] = b
+	}
+
+	return records, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindByPrefix(t *testing.T) {
+	d := newDB(t, nil)
+	for _, k := range []string{"2024-01-01", "2024-01-02", "2024-02-01", "x"} {
+		d.Write("ev", k, k)
+	}
+	m, err := d.FindByPrefix("ev", "2024-01")
+	if err != nil || len(m) != 2 {
+		t.Fatal(m, err)
+	}
+}
+
+func TestReadRange(t *testing.T) {
+	d := newDB(t, nil)
+	for _, k := range []string{"2024-01-01", "2024-01-15", "2024-02-01", "2024-03-01"} {
+		d.Write("ev", k, k)
+	}
+	m, err := d.ReadRange("ev", "2024-01-10", "2024-02-01")
+	if err != nil || len(m) != 2 || m["2024-02-01"] == nil {
+		t.Fatal(m, err)
+	}
+	if m, _ := d.ReadRange("ev", "2025", "2026"); len(m) != 0 {
+		t.Fatal(m)
+	}
+}
+
+func TestReadModifiedSince(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "old", 1)
+	os.Chtimes(filepath.Join(d.dir, "c", "old.json"), time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	cut := time.Now().Add(-time.Minute)
+	d.Write("c", "new", 2)
+	m, err := d.ReadModifiedSince("c", cut)
+	if err != nil || len(m) != 1 || string(m["new"]) != "2\n" {
+		t.Fatal(m, err)
+	}
+}
+
+func TestCollectionModTime(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	d.Write("c", "b", 1)
+	old := time.Now().Add(-time.Hour)
+	for _, p := range []string{"c", "c/a.json", "c/b.json"} {
+		os.Chtimes(filepath.Join(d.dir, p), old, old)
+	}
+	t1, err := d.CollectionModTime("c")
+	if err != nil || !t1.Equal(old) {
+		t.Fatal(t1, err)
+	}
+	d.Write("c", "a", 2)
+	t2, _ := d.CollectionModTime("c")
+	if !t2.After(t1) {
+		t.Fatal(t1, t2)
+	}
+}
+
+func TestExistsMany(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("u", "a", 1)
+	d.Write("u", "c", 1)
+	m, err := d.ExistsMany("u", []string{"a", "b", "c"})
+	if err != nil || !m["a"] || m["b"] || !m["c"] || len(m) != 3 {
+		t.Fatal(m, err)
+	}
+	if _, err := d.ExistsMany("u", []string{"a", "x/y"}); err == nil {
+		t.Fatal("want error")
+	}
+	m, err = d.ExistsMany("none", []string{"a"})
+	if err != nil || m["a"] {
+		t.Fatal(m, err)
+	}
+	if ok, _ := d.Exists("u", "a"); !ok {
+		t.Fatal("record missing")
+	}
+}
+
+func TestListByModTime(t *testing.T) {
+	d := newDB(t, nil)
+	base := time.Now().Add(-time.Hour)
+	for i, name := range []string{"c", "a", "b", "d"} {
+		d.Write("c", name, i)
+		mt := base.Add(time.Duration(i) * time.Minute)
+		if name == "d" {
+			mt = base.Add(2 * time.Minute) // ties with b
+		}
+		os.Chtimes(filepath.Join(d.dir, "c", name+".json"), mt, mt)
+	}
+	asc, err := d.ListByModTime("c", true)
+	if err != nil || !reflect.DeepEqual(asc, []string{"c", "a", "b", "d"}) {
+		t.Fatal(asc, err)
+	}
+	desc, _ := d.ListByModTime("c", false)
+	if !reflect.DeepEqual(desc, []string{"b", "d", "a", "c"}) {
+		t.Fatal(desc)
+	}
+}
+
+func TestQueriesLockTimeout(t *testing.T) {
+	d := newDB(t, &Options{LockTimeout: 5 * time.Millisecond})
+	d.Write("c", "a", 1)
+	unlock, _ := d.lock("c")
+	defer unlock()
+	if _, err := d.FindByPrefix("c", "a"); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal("FindByPrefix", err)
+	}
+	if _, err := d.ReadRange("c", "a", "z"); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal("ReadRange", err)
+	}
+	if _, err := d.ReadModifiedSince("c", time.Time{}); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal("ReadModifiedSince", err)
+	}
+	if _, err := d.ListByModTime("c", true); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal("ListByModTime", err)
+	}
+	if _, err := d.CollectionModTime("c"); !errors.Is(err, ErrLockTimeout) {
+		t.Fatal("CollectionModTime", err)
+	}
+}
+
+func TestAutoCreateOnReadQueries(t *testing.T) {
+	d := newDB(t, &Options{AutoCreateOnRead: true})
+	var buf bytes.Buffer
+	if err := d.StreamAll("nope", &buf); err != nil || buf.String() != "[]" {
+		t.Fatal(buf.String(), err)
+	}
+	buf.Reset()
+	if err := d.ExportJSONL("nope", &buf); err != nil || buf.Len() != 0 {
+		t.Fatal(err)
+	}
+	if m, err := d.ReadModifiedSince("nope", time.Time{}); err != nil || len(m) != 0 {
+		t.Fatal(err)
+	}
+	if l, err := d.ListByModTime("nope", true); err != nil || len(l) != 0 {
+		t.Fatal(err)
+	}
+	if tm, err := d.CollectionModTime("nope"); err != nil || !tm.IsZero() {
+		t.Fatal(err)
+	}
+	if _, err := d.CollectionHash("nope"); err != nil {
+		t.Fatal(err)
+	}
+	e := newDB(t, nil)
+	if _, err := e.CollectionHash("nope"); err == nil || strings.Contains(err.Error(), "nope.json") {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReplaceCollection replaces every record of collection with records, keyed by
+// resource, for full-refresh imports. The new records are written to a
+// sibling temp directory first and renamed into place only once all of them
+// are in, so operations through this driver see either the complete old or
+// the complete new collection. Like SwapDir it takes two renames, leaving a
+// short window where the collection is missing for other processes. The
+// collection's policy and field types carry over, as do its tombstones and
+// the attachments of the records that remain; removed records get
+// tombstones. A collection with nested collections is refused rather than
+// moving them.
+func (d *Driver) ReplaceCollection(collection string, records map[string]interface{}) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to replace!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.checkRemove(collection); err != nil {
+		return err
+	}
+
+	dir := d.collectionDir(collection)
+
+	if d.opts.DryRun {
+		resources, err := d.listResources(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		d.logDryRun("replace collection", collection, resources)
+		return nil
+	}
+
+	if err := checkNoNested(collection, dir); err != nil {
+		return err
+	}
+
+	if err := d.checkCollectionPath(collection); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return err
+	}
+
+	old, err := d.listResources(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	staged, cleanup, err := d.stageCollection(dir, records)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// The old collection moves into the staging area, which cleanup removes.
+	backup := staged + ".old"
+
+	existed := true
+	switch err := os.Rename(dir, backup); {
+	case os.IsNotExist(err):
+		existed = false
+	case err != nil:
+		return err
+	}
+
+	if err := os.Rename(staged, dir); err != nil {
+		if existed {
+			os.Rename(backup, dir)
+		}
+		return err
+	}
+
+	d.forgetCount(collection)
+
+	if !existed {
+		return nil
+	}
+
+	return d.carryOver(collection, backup, old, records)
+}
+
+// carryOver moves what a replaced collection keeps beside its records from
+// the old directory at backup into the new one: the attachments of the
+// records that survive and the tombstones, to which those of the records
+// the replace removed are added.
+func (d *Driver) carryOver(collection string, backup string, old []string, records map[string]interface{}) error {
+	dir := d.collectionDir(collection)
+
+	for resource := range records {
+		path := d.recordPath(collection, resource)
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		if err := moveBinary(filepath.Join(backup, rel)+".json", path+".json"); err != nil {
+			return err
+		}
+	}
+
+	err := os.Rename(filepath.Join(backup, "_tombstones"), filepath.Join(dir, "_tombstones"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	kept := make(map[string]bool, len(records))
+	for resource := range records {
+		if err := d.clearTombstone(d.recordPath(collection, resource) + ".json"); err != nil {
+			return err
+		}
+		kept[d.normalizeResource(resource)] = true
+	}
+
+	for _, resource := range old {
+		if kept[resource] {
+			continue
+		}
+		if err := d.tombstone(d.recordPath(collection, resource) + ".json"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stageCollection writes records, with the collection metadata of dir, into
+// a new temp directory beside dir and returns it, along with a func removing
+// the staging area and whatever is left in it.
+func (d *Driver) stageCollection(dir string, records map[string]interface{}) (string, func(), error) {
+	root, err := os.MkdirTemp(filepath.Dir(dir), "_replace-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.RemoveAll(root) }
+
+	// Staging goes through a driver of its own so the records are stored
+	// exactly as this one would store them.
+	opts := d.opts
+	opts.Exclusive = false
+	opts.ResolveSymlinks = false
+	opts.Root = ""
+
+	stage, err := New(root, &opts)
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	staged := stage.collectionDir("records")
+	if err := os.Mkdir(staged, 0755); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	switch b, err := os.ReadFile(filepath.Join(dir, "_meta.json")); {
+	case err == nil:
+		if err := os.WriteFile(filepath.Join(staged, "_meta.json"), b, 0644); err != nil {
+			cleanup()
+			return "", nil, err
+		}
+	case !os.IsNotExist(err):
+		cleanup()
+		return "", nil, err
+	}
+
+	if err := stage.WriteBatchAtomic("records", records); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return staged, cleanup, nil
+}
+
+// checkNoNested fails with ErrConflict when the collection at dir has nested
+// collections.
+func checkNoNested(collection string, dir string) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() && !strings.HasPrefix(e.Name(), "_") {
+			return fmt.Errorf("%w: unable to replace '%s' - it has nested collection '%s'", ErrConflict, collection, e.Name())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReplaceCollection(t *testing.T) {
+	d := newDB(t, nil)
+	gen := func(g int) map[string]interface{} {
+		m := map[string]interface{}{}
+		for i := 0; i < 5+g%3; i++ {
+			m[fmt.Sprintf("r%d", i)] = g
+		}
+		return m
+	}
+	if err := d.ReplaceCollection("c", gen(0)); err != nil {
+		t.Fatal(err)
+	}
+	d.SetFieldTypes("c", map[string]string{})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			all, err := d.ReadAll("c")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			g, _ := strconv.Atoi(strings.TrimSpace(all[0]))
+			if len(all) != 5+g%3 {
+				t.Errorf("mixed state: gen %d with %d records", g, len(all))
+				return
+			}
+			for _, r := range all {
+				if strings.TrimSpace(r) != strconv.Itoa(g) {
+					t.Errorf("mixed records %v", all)
+					return
+				}
+			}
+		}
+	}()
+	for g := 1; g < 30; g++ {
+		if err := d.ReplaceCollection("c", gen(g)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	ents, _ := os.ReadDir(d.dir)
+	for _, e := range ents {
+		if strings.HasPrefix(e.Name(), "_replace") {
+			t.Fatal("staging left", e.Name())
+		}
+	}
+	if n, _ := d.Count("c"); n != 5+29%3 {
+		t.Fatal(n)
+	}
+	if err := d.ReplaceCollection("c", map[string]interface{}{"bad/x": 1}); err == nil {
+		t.Fatal("expected an error for an invalid resource name")
+	}
+	if n, _ := d.Count("c"); n != 7 {
+		t.Fatal(n)
+	}
+	d.Write("c/nested", "a", 1)
+	if err := d.ReplaceCollection("c", gen(1)); !errors.Is(err, ErrConflict) {
+		t.Fatal(err)
+	}
+	if err := d.ReplaceCollection("x/y", gen(1)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReplaceCollectionCarriesOver(t *testing.T) {
+	for _, shard := range []int{0, 1} {
+		d := newDB(t, &Options{Tombstones: true, Sharding: shard})
+		d.Write("c", "a", 1)
+		d.Write("c", "b", 1)
+		d.Write("c", "gone", 1)
+		d.Delete("c", "b")
+		if err := d.WriteBlob("c", "a", []byte("att")); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.ReplaceCollection("c", map[string]interface{}{"a": 2, "b": 2}); err != nil {
+			t.Fatal(err)
+		}
+		if b, err := d.ReadBlob("c", "a"); err != nil || string(b) != "att" {
+			t.Fatal(b, err)
+		}
+		ts, err := d.Tombstones("c", time.Time{})
+		if err != nil || !reflect.DeepEqual(ts, []string{"gone"}) {
+			t.Fatal(ts, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how the write path retries transient filesystem errors
+// (EAGAIN, EBUSY, ETXTBSY), which network filesystems are prone to. The delay
+// starts at Backoff and doubles after every failed attempt. A zero policy
+// makes a single attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+func (d *Driver) retry(fn func() error) error {
+	backoff := d.opts.RetryPolicy.Backoff
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= d.opts.RetryPolicy.MaxAttempts || !isTransient(err) {
+			return err
+		}
+
+		d.log.Debug("Transient error (attempt %d), retrying in %v: %v \n", attempt, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) ||
+		errors.Is(err, syscall.EBUSY) ||
+		errors.Is(err, syscall.ETXTBSY)
+}
+
+const (
+	fdRetryAttempts = 5
+	fdRetryPause    = 10 * time.Millisecond
+)
+
+// retryOpen retries fn while the process has run out of file descriptors,
+// pausing a little longer each time so other goroutines can release theirs.
+func retryOpen(fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= fdRetryAttempts || !isFDExhausted(err) {
+			return err
+		}
+
+		time.Sleep(time.Duration(attempt) * fdRetryPause)
+	}
+}
+
+func isFDExhausted(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
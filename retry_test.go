@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryTransient(t *testing.T) {
+	d := newDB(t, &Options{RetryPolicy: RetryPolicy{MaxAttempts: 4, Backoff: time.Millisecond}})
+	n := 0
+	err := d.retry(func() error {
+		n++
+		if n < 3 {
+			return &os.PathError{Op: "rename", Err: syscall.EBUSY}
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Fatal(err, n)
+	}
+	n = 0
+	d.retry(func() error { n++; return syscall.ENOENT })
+	if n != 1 {
+		t.Fatal(n)
+	}
+}
+
+func TestReadAllFileDescriptors(t *testing.T) {
+	n := 0
+	err := retryOpen(func() error {
+		n++
+		if n < 3 {
+			return &os.PathError{Op: "open", Err: syscall.EMFILE}
+		}
+		return nil
+	})
+	if err != nil || n != 3 {
+		t.Fatal(err, n)
+	}
+}
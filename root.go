@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// confineDir resolves symlinks in dir and, if root isn't empty, checks that
+// the result lies inside root, also resolved. It returns the resolved dir.
+func confineDir(dir string, root string) (string, error) {
+	real, err := realPath(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if root == "" {
+		return real, nil
+	}
+
+	realRoot, err := realPath(root)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(realRoot, real)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: '%s' resolves to '%s', outside '%s'", ErrOutsideRoot, dir, real, realRoot)
+	}
+
+	return real, nil
+}
+
+// realPath is filepath.EvalSymlinks for paths that may not exist yet: the
+// missing trailing elements are joined to the resolved rest.
+func realPath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	real, err := filepath.EvalSymlinks(path)
+	if !os.IsNotExist(err) {
+		return real, err
+	}
+
+	parent := filepath.Dir(path)
+	if parent == path {
+		return "", err
+	}
+
+	real, err = realPath(parent)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(real, filepath.Base(path)), nil
+}
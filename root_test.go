@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcelliott/lumber"
+)
+
+func TestRoot(t *testing.T) {
+	base := t.TempDir()
+	root := filepath.Join(base, "root")
+	outside := filepath.Join(base, "outside")
+	os.Mkdir(root, 0755)
+	os.Mkdir(outside, 0755)
+	link := filepath.Join(root, "db")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Skip(err)
+	}
+	lg := lumber.NewConsoleLogger(lumber.ERROR)
+	if _, err := New(link, &Options{Logger: lg, Root: root}); !errors.Is(err, ErrOutsideRoot) {
+		t.Fatal(err)
+	}
+	d, err := New(link, &Options{Logger: lg, ResolveSymlinks: true})
+	if err != nil || d.dir != outside {
+		t.Fatal(d.dir, err)
+	}
+	d, err = New(filepath.Join(root, "fresh", "db"), &Options{Logger: lg, Root: root})
+	if err == nil {
+		t.Fatal("parent missing, want mkdir error")
+	}
+	os.Mkdir(filepath.Join(root, "fresh"), 0755)
+	if _, err := New(filepath.Join(root, "fresh", "db"), &Options{Logger: lg, Root: root}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// fieldTypes are the type names SetFieldTypes accepts.
+var fieldTypes = map[string]bool{
+	"string": true,
+	"number": true,
+	"bool":   true,
+	"object": true,
+	"array":  true,
+}
+
+// SetFieldTypes declares the JSON type each listed field of collection's
+// records must have, e.g. {"Age": "number", "Address.City": "string"}. Dotted
+// names reach into nested objects. Writes holding a field of another type fail
+// with ErrFieldType; fields that are absent or null are not checked. A nil or
+// empty map removes the declarations.
+func (d *Driver) SetFieldTypes(collection string, types map[string]string) error {
+	if collection == "" {
+		return errors.New("Missing Collection - unable to set field types!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return err
+	}
+
+	for field, typ := range types {
+		if field == "" {
+			return errors.New("Missing Field - field types need a field name!")
+		}
+		if !fieldTypes[typ] {
+			return fmt.Errorf("Invalid Type '%s' for field '%s' - expected string, number, bool, object or array!", typ, field)
+		}
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	meta, err := d.collectionMeta(collection)
+	if err != nil {
+		return err
+	}
+
+	meta.FieldTypes = types
+	return d.writeCollectionMeta(collection, meta)
+}
+
+// checkFieldTypes validates the record read from r against the field types
+// declared for collection. r is only consumed when there are any.
+func (d *Driver) checkFieldTypes(collection string, resource string, r io.Reader) error {
+	meta, err := d.collectionMeta(collection)
+	if err != nil || len(meta.FieldTypes) == 0 {
+		return err
+	}
+
+	var record interface{}
+	if err := json.NewDecoder(r).Decode(&record); err != nil {
+		return err
+	}
+
+	fields := make([]string, 0, len(meta.FieldTypes))
+	for field := range meta.FieldTypes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		v, ok := lookupField(record, field)
+		if !ok || v == nil {
+			continue
+		}
+
+		if want, got := meta.FieldTypes[field], jsonType(v); got != want {
+			return fmt.Errorf("%w: field '%s' of '%s' must be %s, not %s", ErrFieldType, field, filepath.Join(collection, resource), want, got)
+		}
+	}
+
+	return nil
+}
+
+// lookupField follows a dotted field name through nested objects.
+func lookupField(v interface{}, field string) (interface{}, bool) {
+	for _, name := range strings.Split(field, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		if v, ok = obj[name]; !ok {
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "bool"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+
+	return "null"
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFieldTypes(t *testing.T) {
+	d := newDB(t, nil)
+	if err := d.SetFieldTypes("u", map[string]string{"Age": "integer"}); err == nil {
+		t.Fatal("bad type accepted")
+	}
+	if err := d.SetFieldTypes("u", map[string]string{"Age": "number", "Address.City": "string"}); err != nil {
+		t.Fatal(err)
+	}
+	// User.Age is a json.Number, which is stored as a JSON number.
+	if err := d.Write("u", "a", User{Name: "a", Age: "12"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Write("u", "b", map[string]interface{}{"Age": 3, "Address": map[string]interface{}{"City": "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Write("u", "c", map[string]interface{}{"Age": 3, "Address": map[string]interface{}{"City": 4}})
+	if !errors.Is(err, ErrFieldType) {
+		t.Fatal(err)
+	}
+	if err := d.WriteFrom("u", "d", strings.NewReader(`{"Age":"x"}`)); !errors.Is(err, ErrFieldType) {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(d.dir, "u", "d.json")); err == nil {
+		t.Fatal("stored")
+	}
+	d2 := openDB(t, d.dir, nil)
+	if err := d2.Write("u", "e", map[string]string{"Age": "x"}); !errors.Is(err, ErrFieldType) {
+		t.Fatal(err)
+	}
+}
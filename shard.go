@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxSharding is the deepest layout Options.Sharding allows: one level per
+// byte of the 32-bit name hash.
+const maxSharding = 4
+
+// shardDir returns the shard directories, relative to the collection
+// directory, that hold the record file called name: one "_xx" level per
+// Options.Sharding, taken from the hash of the name. The leading underscore
+// keeps shards apart from nested collections and out of collection listings.
+func (d *Driver) shardDir(name string) string {
+	if d.opts.Sharding <= 0 {
+		return ""
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := fmt.Sprintf("%08x", h.Sum32())
+
+	levels := make([]string, d.opts.Sharding)
+	for i := range levels {
+		levels[i] = "_" + sum[2*i:2*i+2]
+	}
+
+	return filepath.Join(levels...)
+}
+
+// collectionOf returns the collection directory of the record file at path,
+// skipping its shard directories.
+func (d *Driver) collectionOf(path string) string {
+	dir := filepath.Dir(path)
+	for i := 0; i < d.opts.Sharding; i++ {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// isShardDir reports whether e is a shard directory.
+func isShardDir(e fs.DirEntry) bool {
+	return e.IsDir() && isShardName(e.Name())
+}
+
+// isShardName reports whether name is that of a shard: "_" and two hex digits.
+func isShardName(name string) bool {
+	return len(name) == 3 && name[0] == '_' && strings.Trim(name[1:], "0123456789abcdef") == ""
+}
+
+// recordFile is a record file found by recordFiles.
+type recordFile struct {
+	fs.DirEntry
+	path string
+}
+
+// recordFiles returns the record files of the collection directory dir,
+// descending into its shards when Options.Sharding is set. A missing dir is
+// reported as such; shards vanishing underneath are not.
+func (d *Driver) recordFiles(dir string) ([]recordFile, error) {
+	return d.readShard(dir, d.opts.Sharding, true)
+}
+
+func (d *Driver) readShard(dir string, depth int, top bool) ([]recordFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !top && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var files []recordFile
+
+	for _, e := range entries {
+		switch {
+		case depth == 0 && isRecordFile(e):
+			files = append(files, recordFile{DirEntry: e, path: filepath.Join(dir, e.Name())})
+		case depth > 0 && isShardDir(e):
+			sub, err := d.readShard(filepath.Join(dir, e.Name()), depth-1, false)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, sub...)
+		}
+	}
+
+	return files, nil
+}
+
+// Reshard moves every record of collection to where the current
+// Options.Sharding setting puts it, removing shard directories left empty, and
+// returns how many records moved. Run it after changing Sharding on a
+// database with data; until then records in the old layout aren't found.
+func (d *Driver) Reshard(collection string) (int, error) {
+	if collection == "" {
+		return 0, errors.New("Missing Collection - unable to reshard!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return 0, err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dir := d.collectionDir(collection)
+
+	// Records may sit at any depth, whatever layout they were written with.
+	var files []recordFile
+	for depth := 0; depth <= maxSharding; depth++ {
+		found, err := d.readShard(dir, depth, true)
+		if err != nil {
+			return 0, err
+		}
+		files = append(files, found...)
+	}
+
+	moved := 0
+	for _, f := range files {
+		target := filepath.Join(dir, d.shardDir(trimRecordExt(f.Name())), f.Name())
+		if f.path == target {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return moved, err
+		}
+
+		if err := os.Rename(f.path, target); err != nil {
+			return moved, err
+		}
+		moved++
+
+		if err := moveBinary(f.path, target); err != nil {
+			return moved, err
+		}
+
+		// Drop the shard directories the record left, as far up as they are
+		// empty; removing a directory that still has entries just fails.
+		for old := filepath.Dir(f.path); old != dir && isShardName(filepath.Base(old)); old = filepath.Dir(old) {
+			if os.Remove(old) != nil {
+				break
+			}
+		}
+	}
+
+	d.forgetCount(collection)
+	return moved, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSharding(t *testing.T) {
+	if _, err := New(t.TempDir(), &Options{Sharding: 5}); err == nil {
+		t.Fatal("accepted")
+	}
+	for _, depth := range []int{1, 2} {
+		d := newDB(t, &Options{Sharding: depth, Dedup: depth == 2, Tombstones: true})
+		for i := 0; i < 50; i++ {
+			if err := d.Write("u", fmt.Sprint("r", i), i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		d.Write("u/nested", "x", 1)
+		top, _ := os.ReadDir(filepath.Join(d.dir, "u"))
+		for _, e := range top {
+			if strings.HasSuffix(e.Name(), ".json") {
+				t.Fatal("unsharded", e.Name())
+			}
+		}
+		var v int
+		if err := d.Read("u", "r7", &v); err != nil || v != 7 {
+			t.Fatal(v, err)
+		}
+		all, _ := d.ReadAll("u")
+		n, _ := d.Count("u")
+		names, _ := d.List("u")
+		if len(all) != 50 || n != 50 || len(names) != 50 {
+			t.Fatal(len(all), n, len(names))
+		}
+		cs, _ := d.CollectionsRecursive()
+		if len(cs) != 2 {
+			t.Fatal(cs)
+		}
+		if err := d.Delete("u", "r7"); err != nil {
+			t.Fatal(err)
+		}
+		if ts, _ := d.Tombstones("u", time.Time{}); len(ts) != 1 {
+			t.Fatal(ts)
+		}
+	}
+}
+
+func TestReshard(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	d := openDB(t, dir, nil)
+	for i := 0; i < 20; i++ {
+		d.Write("u", fmt.Sprint("r", i), i)
+	}
+	d2 := openDB(t, dir, &Options{Sharding: 2})
+	if n, _ := d2.Count("u"); n != 0 {
+		t.Fatal(n)
+	}
+	if n, err := d2.Reshard("u"); n != 20 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, _ := d2.Reshard("u"); n != 0 {
+		t.Fatal(n)
+	}
+	if all, _ := d2.ReadAll("u"); len(all) != 20 {
+		t.Fatal(len(all))
+	}
+	if err := d2.Read("u", "r7", new(int)); err != nil {
+		t.Fatal(err)
+	}
+	d3 := openDB(t, dir, &Options{Sharding: 1})
+	if n, err := d3.Reshard("u"); n != 20 || err != nil {
+		t.Fatal(n, err)
+	}
+	d4 := openDB(t, dir, nil)
+	if n, _ := d4.Reshard("u"); n != 20 {
+		t.Fatal(n)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "u"))
+	if len(entries) != 20 {
+		t.Fatal(len(entries))
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// WriteFrom streams a pre-serialized record from r into place without
+// buffering it in memory. The bytes are validated as a single JSON value while
+// they are copied; if validation fails nothing is stored.
+func (d *Driver) WriteFrom(collection string, resource string, r io.Reader) error {
+	err := d.validateCollectionResource(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	fnlPath := d.recordPath(collection, resource) + ".json"
+
+	added, evict, err := d.admission(collection, resource)
+	if err != nil {
+		return err
+	}
+
+	if err := d.prepareCollection(collection, resource); err != nil {
+		return err
+	}
+
+	f, err := createTemp(fnlPath)
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	err = validateJSONStream(io.TeeReader(r, f))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = d.checkFieldTypesFile(collection, resource, tmpPath)
+	}
+	if err == nil && d.opts.Dedup {
+		err = d.storeBlobFile(d.collectionDir(collection), tmpPath)
+	}
+	if err == nil && d.opts.Envelope {
+		err = d.wrapEnvelopeFile(fnlPath, tmpPath)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if !d.opts.Dedup {
+		encPath, encTmpPath, err := d.encodeRecordFile(fnlPath, tmpPath)
+		if err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		fnlPath, tmpPath = encPath, encTmpPath
+	}
+
+	if err := d.makeRoom(collection, evict); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := d.commitFile(tmpPath, fnlPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := removeVariants(fnlPath); err != nil {
+		return err
+	}
+
+	if err := d.clearTombstone(fnlPath); err != nil {
+		return err
+	}
+
+	if added {
+		d.adjustCount(collection, 1)
+	}
+
+	return nil
+}
+
+// validateJSONStream consumes r token by token, checking that it holds exactly
+// one well-formed JSON value.
+func validateJSONStream(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	depth := 0
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return fmt.Errorf("Invalid JSON - %w", err)
+		}
+
+		if delim, ok := tok.(json.Delim); ok {
+			switch delim {
+			case '{', '[':
+				depth++
+			case '}', ']':
+				depth--
+			}
+		}
+
+		if depth == 0 {
+			break
+		}
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("Invalid JSON - unexpected data after the record!")
+	}
+
+	return nil
+}
+
+// checkFieldTypesFile runs checkFieldTypes over a record already on disk.
+func (d *Driver) checkFieldTypesFile(collection string, resource string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return d.checkFieldTypes(collection, resource, f)
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteFrom(t *testing.T) {
+	d := newDB(t, nil)
+	in := `{"a":[1,2,{"b":"c"}]}`
+	if err := d.WriteFrom("c", "a", bytes.NewReader([]byte(in))); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := d.ReadRaw("c", "a")
+	if string(b) != in {
+		t.Fatal(string(b))
+	}
+	for _, bad := range []string{`{"a":`, `{} {}`, ``, `{"a" 1}`, `]`} {
+		if err := d.WriteFrom("c", "a", strings.NewReader(bad)); err == nil {
+			t.Fatal(bad)
+		}
+	}
+	b, _ = d.ReadRaw("c", "a")
+	if string(b) != in {
+		t.Fatal(string(b))
+	}
+	if err := d.WriteFrom("c", "n", strings.NewReader(" 42 \n")); err != nil {
+		t.Fatal(err)
+	}
+}
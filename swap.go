@@ -0,0 +1,64 @@
+package main
+
+import "os"
+
+// Swap exchanges the contents of two records in the same collection. The
+// files are moved with three renames through a temporary name, so a concurrent
+// observer may briefly find one of the two missing but never sees both holding
+// the same value.
+func (d *Driver) Swap(collection string, resourceA string, resourceB string) error {
+	if err := d.validateCollectionResource(collection, resourceA); err != nil {
+		return err
+	}
+
+	if err := d.validateCollectionResource(collection, resourceB); err != nil {
+		return err
+	}
+
+	if resourceA == resourceB {
+		return nil
+	}
+
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := d.checkRemove(collection); err != nil {
+		return err
+	}
+
+	pathA, formatA, err := resolveRecord(d.recordPath(collection, resourceA) + ".json")
+	if err != nil {
+		return recordError(collection, resourceA, err)
+	}
+
+	pathB, formatB, err := resolveRecord(d.recordPath(collection, resourceB) + ".json")
+	if err != nil {
+		return recordError(collection, resourceB, err)
+	}
+
+	// Each body keeps its stored format, so the extensions travel with it.
+	baseA, _, _ := splitRecordName(pathA)
+	baseB, _, _ := splitRecordName(pathB)
+	newA, newB := baseA+formatB.ext, baseB+formatA.ext
+	tmpPath := pathA + ".swap"
+
+	if err := os.Rename(pathA, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(pathB, newA); err != nil {
+		os.Rename(tmpPath, pathA)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, newB); err != nil {
+		os.Rename(newA, pathB)
+		os.Rename(tmpPath, pathA)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSwap(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	d.Write("c", "b", 2)
+	if err := d.Swap("c", "a", "b"); err != nil {
+		t.Fatal(err)
+	}
+	var a, b int
+	d.Read("c", "a", &a)
+	d.Read("c", "b", &b)
+	if a != 2 || b != 1 {
+		t.Fatal(a, b)
+	}
+	if !errors.Is(d.Swap("c", "a", "zz"), ErrRecordNotFound) {
+		t.Fatal("expected ErrRecordNotFound")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SwapDir replaces the database directory with newDir, which must live on the
+// same filesystem so both steps are plain renames. The current directory is
+// kept alongside as "<dir>.bak-<unix time>", and its path is logged.
+//
+// Each rename is atomic, but there is a short window between them where the
+// database path does not exist. Operations through this driver are held off
+// for the duration of the swap, other processes are not.
+func (d *Driver) SwapDir(newDir string) error {
+	newDir = filepath.Clean(newDir)
+
+	fi, err := os.Stat(newDir)
+	if err != nil {
+		return err
+	}
+
+	if !fi.IsDir() {
+		return fmt.Errorf("Unable to swap in '%s' - not a directory!", newDir)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Wait for in-flight operations to finish; new ones queue up on d.mutex.
+	// Operations never take d.mutex while holding a collection lock, only
+	// cacheMutex, so this can't deadlock.
+	for _, m := range d.mutexes {
+		m.Lock()
+		defer m.Unlock()
+	}
+
+	backup := fmt.Sprintf("%s.bak-%d", d.dir, time.Now().Unix())
+
+	if err := os.Rename(d.dir, backup); err != nil {
+		return err
+	}
+
+	if err := os.Rename(newDir, d.dir); err != nil {
+		os.Rename(backup, d.dir)
+		return err
+	}
+
+	if d.lockFile != nil {
+		unlockDir(d.lockFile)
+		d.lockFile = nil
+
+		f, err := lockDir(d.dir, d.opts.BreakStaleLock)
+		if err != nil {
+			return err
+		}
+		d.lockFile = f
+	}
+
+	// The collection locks are keyed by name, not directory, so they stay:
+	// replacing them would let an operation that fetched a lock before the
+	// swap run alongside one that fetched its replacement after it.
+	d.cacheMutex.Lock()
+	d.metas = make(map[string]collectionMeta)
+	d.counts = make(map[string]int)
+	d.cacheMutex.Unlock()
+
+	d.log.Info("Swapped '%s' into '%s', previous data kept at '%s' \n", newDir, d.dir, backup)
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSwapDir(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("c", "a", 1)
+	nd := t.TempDir() + "/next"
+	n := openDB(t, nd, nil)
+	n.Write("c", "a", 2)
+	err := d.SwapDir(nd)
+	bak := matchBak(t, d.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	if d.Read("c", "a", &v); v != 2 {
+		t.Fatal(v)
+	}
+	if _, err := os.Stat(bak + "/c/a.json"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSwapDirUnderLoad(t *testing.T) {
+	d := newDB(t, &Options{LockStats: true, LockTimeout: 0})
+	d.SetCollectionPolicy("c", Policy{MaxRecords: 1000})
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				d.Write("c", fmt.Sprintf("w%d-%d", w, i%5), i)
+			}
+		}(w)
+	}
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		for i := 0; i < 300; i++ {
+			nd := filepath.Join(filepath.Dir(d.dir), fmt.Sprintf("new%d", i))
+			os.Mkdir(nd, 0755)
+			d.SwapDir(nd)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock")
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestSwapDirKeepsLocks(t *testing.T) {
+	d := newDB(t, nil)
+	held := d.getOrCreateMutex("c")
+	nd := t.TempDir() + "/next"
+	if err := os.Mkdir(nd, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SwapDir(nd); err != nil {
+		t.Fatal(err)
+	}
+	// An operation that fetched the lock before the swap must still exclude
+	// one that fetches it afterwards.
+	if d.getOrCreateMutex("c") != held {
+		t.Fatal("collection lock replaced by SwapDir")
+	}
+}
+
+func matchBak(t *testing.T, dir string) string {
+	m, _ := filepath.Glob(dir + ".bak-*")
+	if len(m) != 1 {
+		t.Fatalf("backups %v", m)
+	}
+	return m[0]
+}
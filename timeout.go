@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// withTimeout runs op, giving up on it with ErrTimeout once Options.OpTimeout
+// has passed. File operations can't be interrupted, so op keeps running in
+// the background and still releases its locks and temp files when it
+// returns.
+func (d *Driver) withTimeout(op func() error) error {
+	if d.opts.OpTimeout <= 0 {
+		return op()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- op() }()
+
+	timer := time.NewTimer(d.opts.OpTimeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		d.log.Warn("Operation abandoned after %v, it may still complete in the background \n", d.opts.OpTimeout)
+		return fmt.Errorf("%w after %v", ErrTimeout, d.opts.OpTimeout)
+	}
+}
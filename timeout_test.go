@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDeadline(t *testing.T) {
+	d := newDB(t, &Options{OpTimeout: 50 * time.Millisecond})
+	if err := d.Write("c", "a", 1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := d.Read("c", "a", &n); err != nil || n != 1 {
+		t.Fatal(n, err)
+	}
+	// A stuck operation holding the collection stands in for hung storage.
+	unlock, _ := d.lock("c")
+	start := time.Now()
+	if err := d.Write("c", "b", 2); !errors.Is(err, ErrTimeout) {
+		t.Fatal(err)
+	}
+	if err := d.Read("c", "a", &n); !errors.Is(err, ErrTimeout) {
+		t.Fatal(err)
+	}
+	if err := d.Delete("c", "a"); !errors.Is(err, ErrTimeout) {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal(time.Since(start))
+	}
+	unlock()
+	time.Sleep(50 * time.Millisecond)
+	if err := d.Read("c", "b", &n); err != nil || n != 2 {
+		t.Fatal("background write should land", err)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Under Options.Tombstones every removed record leaves a marker with the time
+// of its removal in the collection's _tombstones directory, under the record's
+// file name, so incremental sync can learn about deletions.
+type tombstone struct {
+	Deleted time.Time
+}
+
+// tombstonePath returns where the marker for the record at path goes. Every
+// stored variant of a record shares one marker.
+func (d *Driver) tombstonePath(path string) string {
+	return filepath.Join(d.collectionOf(path), "_tombstones", trimRecordExt(filepath.Base(path))+recordFormats[0].ext)
+}
+
+// tombstone marks the record file at path, which was just removed, as deleted.
+func (d *Driver) tombstone(path string) error {
+	if !d.opts.Tombstones {
+		return nil
+	}
+
+	b, err := json.Marshal(tombstone{Deleted: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	marker := d.tombstonePath(path)
+
+	if err := os.MkdirAll(filepath.Dir(marker), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(marker+".tmp", b, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(marker+".tmp", marker)
+}
+
+// clearTombstone drops the marker of a record that has been written again.
+func (d *Driver) clearTombstone(path string) error {
+	if !d.opts.Tombstones {
+		return nil
+	}
+
+	if err := os.Remove(d.tombstonePath(path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Tombstones returns the records of collection deleted after since, while
+// Options.Tombstones was set.
+func (d *Driver) Tombstones(collection string, since time.Time) ([]string, error) {
+	if collection == "" {
+		return nil, errors.New("Missing Collection - unable to read tombstones!")
+	}
+
+	if err := d.validateCollection(collection); err != nil {
+		return nil, err
+	}
+
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	dir := filepath.Join(d.collectionDir(collection), "_tombstones")
+
+	var deleted []string
+	err = eachTombstone(dir, func(name string, t tombstone) error {
+		if t.Deleted.After(since) {
+			deleted = append(deleted, d.decodeKey(trimRecordExt(name)))
+		}
+		return nil
+	})
+
+	return deleted, err
+}
+
+// PurgeTombstones removes the tombstones of every collection recorded before
+// the given time and returns how many there were. Under Options.DryRun they
+// are only counted.
+func (d *Driver) PurgeTombstones(before time.Time) (int, error) {
+	purged := 0
+
+	// Walk the tree rather than listing collections: a collection whose
+	// records were all deleted still has tombstones to purge.
+	err := filepath.WalkDir(d.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !e.IsDir() || path == d.dir {
+			return nil
+		}
+
+		if e.Name() != "_tombstones" {
+			if strings.HasPrefix(e.Name(), "_") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(d.dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+
+		n, err := d.purgeTombstones(filepath.ToSlash(rel), before)
+		purged += n
+		if err != nil {
+			return err
+		}
+
+		return filepath.SkipDir
+	})
+
+	return purged, err
+}
+
+func (d *Driver) purgeTombstones(collection string, before time.Time) (int, error) {
+	unlock, err := d.lock(collection)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	dir := filepath.Join(d.collectionDir(collection), "_tombstones")
+
+	var purged []string
+	err = eachTombstone(dir, func(name string, t tombstone) error {
+		if !t.Deleted.Before(before) {
+			return nil
+		}
+		if !d.opts.DryRun {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				return err
+			}
+		}
+		purged = append(purged, d.decodeKey(trimRecordExt(name)))
+		return nil
+	})
+
+	if d.opts.DryRun && len(purged) > 0 {
+		d.logDryRun("purge tombstones of", collection, purged)
+	}
+
+	return len(purged), err
+}
+
+// eachTombstone calls fn with every tombstone in dir, which need not exist.
+func eachTombstone(dir string, fn func(name string, t tombstone) error) error {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if !isRecordFile(e) {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var t tombstone
+		if err := json.Unmarshal(b, &t); err != nil {
+			return err
+		}
+
+		if err := fn(e.Name(), t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	d := newDB(t, &Options{Tombstones: true})
+	start := time.Now().Add(-time.Second)
+	d.Write("c", "a", 1)
+	d.Write("c", "b", 1)
+	d.Write("c/n", "x", 1)
+	if err := d.Delete("c", "a"); err != nil {
+		t.Fatal(err)
+	}
+	d.Delete("c/n", "x")
+	if err := d.Read("c", "a", new(int)); !errors.Is(err, ErrRecordNotFound) {
+		t.Fatal(err)
+	}
+	all, _ := d.ReadAll("c")
+	if len(all) != 1 {
+		t.Fatal(all)
+	}
+	ts, err := d.Tombstones("c", start)
+	if err != nil || len(ts) != 1 || ts[0] != "a" {
+		t.Fatal(ts, err)
+	}
+	if ts, _ := d.Tombstones("c", time.Now().Add(time.Second)); len(ts) != 0 {
+		t.Fatal(ts)
+	}
+	d.Write("c", "a", 2)
+	if ts, _ := d.Tombstones("c", start); len(ts) != 0 {
+		t.Fatal(ts)
+	}
+	d.Delete("c", "a")
+	if n, err := d.PurgeTombstones(start); n != 0 || err != nil {
+		t.Fatal(n, err)
+	}
+	if n, err := d.PurgeTombstones(time.Now().Add(time.Second)); n != 2 || err != nil {
+		t.Fatal(n, err)
+	}
+	if cs, _ := d.CollectionsRecursive(); len(cs) != 1 {
+		t.Fatal(cs)
+	}
+}
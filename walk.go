@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Walk calls fn for every record in every collection, nested ones included,
+// and stops at the first error fn returns. Internal "_" directories are
+// skipped. Each collection's lock is held while its records are visited, so
+// fn must not write to the collection it is handed.
+func (d *Driver) Walk(fn func(collection string, resource string, raw json.RawMessage) error) error {
+	collections, err := d.CollectionsRecursive()
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range collections {
+		if err := d.walkCollection(collection, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *Driver) walkCollection(collection string, fn func(collection string, resource string, raw json.RawMessage) error) error {
+	unlock, err := d.rlock(collection)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	resources, err := d.listResources(d.collectionDir(collection))
+	if err != nil {
+		return err
+	}
+
+	for _, resource := range resources {
+		b, err := d.readRecord(d.recordPath(collection, resource) + ".json")
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := fn(collection, resource, b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
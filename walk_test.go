@@ -0,0 +1,23 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	d := newDB(t, nil)
+	d.Write("a", "1", 1)
+	d.Write("a", "2", 1)
+	d.Write("b", "1", 1)
+	n := 0
+	err := d.Walk(func(c, r string, raw json.RawMessage) error { n++; return nil })
+	if err != nil || n != 3 {
+		t.Fatal(n, err)
+	}
+	stop := errors.New("stop")
+	if d.Walk(func(c, r string, raw json.RawMessage) error { return stop }) != stop {
+		t.Fatal("walk not stopped by the callback error")
+	}
+}